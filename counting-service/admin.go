@@ -0,0 +1,99 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// SchemaVerifier is implemented by stores backed by a database schema that
+// can be checked for drift without modifying it.
+type SchemaVerifier interface {
+	VerifySchema(ctx context.Context) (SchemaReport, error)
+}
+
+// SchemaReport describes how the live schema differs from what the service
+// expects.
+type SchemaReport struct {
+	OK            bool                `json:"ok"`
+	Table         string              `json:"table"`
+	TableExists   bool                `json:"table_exists"`
+	Discrepancies []SchemaDiscrepancy `json:"discrepancies"`
+}
+
+// SchemaDiscrepancy is a single difference between the expected and actual
+// schema.
+type SchemaDiscrepancy struct {
+	Column   string `json:"column,omitempty"`
+	Problem  string `json:"problem"`
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+}
+
+type errorResponse struct {
+	Message string `json:"message"`
+}
+
+// bearerToken extracts the token from an "Authorization: Bearer" header.
+func bearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// tokenMatches compares tokens in constant time. Both sides are hashed first
+// so the comparison does not leak the expected token's length either.
+func tokenMatches(got, want string) bool {
+	gotSum := sha256.Sum256([]byte(got))
+	wantSum := sha256.Sum256([]byte(want))
+	return subtle.ConstantTimeCompare(gotSum[:], wantSum[:]) == 1
+}
+
+// requireAdminToken gates admin endpoints behind ADMIN_TOKEN. When no token
+// is configured the admin endpoints are disabled entirely.
+func requireAdminToken(adminToken string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if adminToken == "" {
+				writeJSON(w, http.StatusForbidden, errorResponse{Message: "admin endpoints are disabled; set ADMIN_TOKEN to enable them"})
+				return
+			}
+			if !tokenMatches(bearerToken(r), adminToken) {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+				writeJSON(w, http.StatusUnauthorized, errorResponse{Message: "missing or invalid admin token"})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// VerifySchemaHandler reports whether the store's schema matches what the
+// service expects, without attempting to repair it.
+func VerifySchemaHandler(store CounterStore, timeout time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		verifier, ok := store.(SchemaVerifier)
+		if !ok {
+			writeJSON(w, http.StatusNotImplemented, errorResponse{Message: "the configured storage mode has no schema to verify"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		report, err := verifier.VerifySchema(ctx)
+		if err != nil {
+			writeJSON(w, http.StatusServiceUnavailable, errorResponse{Message: "DB Error: " + err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, report)
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultBadgeLabel      = "count"
+	defaultBadgeColor      = "4c1"
+	defaultBadgeLabelColor = "555"
+	badgeUnavailableColor  = "9f9f9f"
+	badgeMaxLabelLen       = 32
+	badgeCacheControl      = "public, max-age=60"
+)
+
+// badgeColorPattern accepts hex colors (without '#') and plain color names,
+// which keeps query parameters from injecting markup into the SVG.
+var badgeColorPattern = regexp.MustCompile(`^([0-9a-fA-F]{3}|[0-9a-fA-F]{6}|[a-z]{3,20})$`)
+
+var badgeHexColorPattern = regexp.MustCompile(`^[0-9a-fA-F]+$`)
+
+const badgeTemplate = `<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="20" role="img" aria-label="%[3]s: %[4]s">
+<title>%[3]s: %[4]s</title>
+<linearGradient id="s" x2="0" y2="100%%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>
+<clipPath id="r"><rect width="%[1]d" height="20" rx="3" fill="#fff"/></clipPath>
+<g clip-path="url(#r)"><rect width="%[2]d" height="20" fill="%[5]s"/><rect x="%[2]d" width="%[6]d" height="20" fill="%[7]s"/><rect width="%[1]d" height="20" fill="url(#s)"/></g>
+<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">
+<text x="%[8]d" y="14">%[3]s</text><text x="%[9]d" y="14">%[4]s</text>
+</g>
+</svg>
+`
+
+// badgeTextWidth roughly estimates the rendered width of 11px Verdana text.
+func badgeTextWidth(text string) int {
+	return len([]rune(text))*7 + 10
+}
+
+func badgeColor(raw, fallback string) string {
+	if raw == "" || !badgeColorPattern.MatchString(raw) {
+		raw = fallback
+	}
+	if badgeHexColorPattern.MatchString(raw) {
+		return "#" + raw
+	}
+	return raw
+}
+
+// renderBadge returns a shields.io-style SVG badge.
+func renderBadge(label, value, labelColor, valueColor string) string {
+	labelWidth := badgeTextWidth(label)
+	valueWidth := badgeTextWidth(value)
+	return fmt.Sprintf(badgeTemplate,
+		labelWidth+valueWidth,
+		labelWidth,
+		html.EscapeString(label),
+		html.EscapeString(value),
+		labelColor,
+		valueWidth,
+		valueColor,
+		labelWidth/2,
+		labelWidth+valueWidth/2,
+	)
+}
+
+// BadgeHandler serves the current count as an SVG badge. It reads the count
+// without incrementing it. The label and colors can be customised with the
+// label, label_color and color query parameters.
+func BadgeHandler(store CounterStore, timeout time.Duration, displayFloor int64) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		label := query.Get("label")
+		if label == "" {
+			label = defaultBadgeLabel
+		}
+		if runes := []rune(label); len(runes) > badgeMaxLabelLen {
+			label = string(runes[:badgeMaxLabelLen])
+		}
+		labelColor := badgeColor(query.Get("label_color"), defaultBadgeLabelColor)
+		valueColor := badgeColor(query.Get("color"), defaultBadgeColor)
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		value := "unavailable"
+		if count, err := store.GetCount(ctx, defaultCounterName); err == nil {
+			value = strconv.FormatInt(displayCount(count, displayFloor), 10)
+		} else {
+			valueColor = "#" + badgeUnavailableColor
+		}
+
+		body := renderBadge(label, value, labelColor, valueColor)
+		w.Header().Set("Content-Type", "image/svg+xml; charset=utf-8")
+		w.Header().Set("Cache-Control", badgeCacheControl)
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodHead {
+			return
+		}
+		_, _ = w.Write([]byte(body))
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const defaultBootstrapRetries = 5
+
+// bootstrapBackoff is the first wait between bootstrap attempts. It doubles
+// on each retry, with jitter so instances that collided do not collide again.
+const bootstrapBackoff = 50 * time.Millisecond
+
+// bootstrapConflictCodes are the errors instances starting together against
+// a fresh database can hit while creating the same objects. They go away on
+// retry, once one instance's DDL has committed.
+var bootstrapConflictCodes = map[string]bool{
+	"40001": true, // serialization_failure, CockroachDB's usual report
+	"40P01": true, // deadlock_detected
+	"42P07": true, // duplicate_table: CREATE TABLE IF NOT EXISTS raced
+	"42710": true, // duplicate_object: ADD COLUMN IF NOT EXISTS raced
+	"23505": true, // unique_violation on a catalog table, as Postgres reports the same races
+}
+
+func isBootstrapConflict(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && bootstrapConflictCodes[pgErr.Code]
+}
+
+// ensureSchema creates the counters table and seeds the default row. It only
+// does work until the first success; after that it is a flag check, so the
+// increment path pays no extra round trips. Only one caller per instance
+// bootstraps at a time; callers waiting behind it usually find the work
+// done. Across instances every step is idempotent, and the seed is an
+// INSERT ... ON CONFLICT DO NOTHING, so exactly one instance seeds the row.
+// Conflicts between instances creating the schema together are retried with
+// backoff, up to bootstrapRetries times.
+func (c *CockroachStore) ensureSchema(ctx context.Context, conn *sql.Conn) error {
+	if c.schemaReady.Load() {
+		return nil
+	}
+
+	select {
+	case c.bootstrapSem <- struct{}{}:
+		defer func() { <-c.bootstrapSem }()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if c.schemaReady.Load() {
+		return nil
+	}
+	defer c.timeStatement("ensure_schema")()
+
+	backoff := bootstrapBackoff
+	for attempt := 0; ; attempt++ {
+		err := c.bootstrap(ctx, conn)
+		if err == nil {
+			c.schemaReady.Store(true)
+			return nil
+		}
+		if !isBootstrapConflict(err) || attempt >= c.bootstrapRetries {
+			return err
+		}
+
+		wait := backoff/2 + rand.N(backoff)
+		slog.Info("Schema bootstrap conflicted with another instance; retrying", "attempt", attempt+1, "retry_in", wait, "error", err)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+func (c *CockroachStore) bootstrap(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS counters (
+		name STRING PRIMARY KEY,
+		count BIGINT NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+	if c.hmacKey != nil {
+		if err := c.ensureChecksumColumn(ctx, conn); err != nil {
+			return err
+		}
+	}
+
+	if err := c.migrateLegacyRow(ctx, conn); err != nil {
+		return err
+	}
+	return c.seedRow(ctx, conn)
+}
@@ -0,0 +1,137 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// TestEnsureSchemaConcurrentInstances starts several instances against one
+// fresh database at once. The first CREATE TABLE stays open until a second
+// instance collides with it and gets the 23505 catalog race Postgres
+// reports; every instance must still come up, and only one may seed.
+func TestEnsureSchemaConcurrentInstances(t *testing.T) {
+	const instances = 8
+
+	db := newFakeDB()
+	var (
+		mu        sync.Mutex
+		creating  bool
+		created   bool
+		conflicts int
+		collided  = make(chan struct{})
+		once      sync.Once
+	)
+	db.beforeStatement = func(kind string) error {
+		if kind != "create_table" {
+			return nil
+		}
+		mu.Lock()
+		switch {
+		case created:
+			mu.Unlock()
+			return nil
+		case creating:
+			conflicts++
+			mu.Unlock()
+			once.Do(func() { close(collided) })
+			return &pgconn.PgError{Code: "23505", Message: `duplicate key value violates unique constraint "pg_type_typname_nsp_index"`}
+		}
+		creating = true
+		mu.Unlock()
+
+		select {
+		case <-collided:
+		case <-time.After(2 * time.Second):
+			t.Error("no instance collided with the first CREATE TABLE")
+		}
+		mu.Lock()
+		creating, created = false, true
+		mu.Unlock()
+		return nil
+	}
+
+	stores := make([]*CockroachStore, instances)
+	for i := range stores {
+		stores[i] = newFakeCockroachStore(t, db)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	start := make(chan struct{})
+	errs := make(chan error, instances)
+	var wg sync.WaitGroup
+	for _, store := range stores {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			conn, err := store.conn(ctx)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer conn.Close()
+			errs <- store.ensureSchema(ctx, conn)
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("ensureSchema: %v", err)
+		}
+	}
+	if conflicts == 0 {
+		t.Error("expected at least one bootstrap conflict to be retried")
+	}
+	if db.seeded != 1 {
+		t.Errorf("default row seeded %d times, want 1", db.seeded)
+	}
+	for i, store := range stores {
+		if !store.schemaReady.Load() {
+			t.Errorf("instance %d: schema not marked ready", i)
+		}
+	}
+}
+
+// TestEnsureSchemaGivesUpAfterRetries checks a conflict that never clears is
+// returned once bootstrapRetries is spent.
+func TestEnsureSchemaGivesUpAfterRetries(t *testing.T) {
+	db := newFakeDB()
+	attempts := 0
+	db.beforeStatement = func(kind string) error {
+		if kind == "create_table" {
+			attempts++
+			return &pgconn.PgError{Code: "40001", Message: "restart transaction"}
+		}
+		return nil
+	}
+	store := newFakeCockroachStore(t, db)
+	store.bootstrapRetries = 2
+
+	ctx := context.Background()
+	conn, err := store.conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := store.ensureSchema(ctx, conn); !isBootstrapConflict(err) {
+		t.Fatalf("ensureSchema error = %v, want the serialization failure", err)
+	}
+	if attempts != 3 {
+		t.Errorf("CREATE TABLE attempted %d times, want 3", attempts)
+	}
+	if store.schemaReady.Load() {
+		t.Error("schema marked ready after failing")
+	}
+}
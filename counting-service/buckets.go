@@ -0,0 +1,130 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const defaultBucketGranularity = 1 * time.Hour
+const defaultBucketRetention = 24 * time.Hour
+const maxBuckets = 10000
+
+// Bucket is the number of increments recorded in [Start, Start+granularity).
+type Bucket struct {
+	Start time.Time `json:"start"`
+	Count int64     `json:"count"`
+}
+
+// BucketRecorder counts increments into a ring of fixed-width time buckets.
+// Buckets are kept in memory per instance, so they start empty on restart
+// and only cover increments served by this process.
+type BucketRecorder struct {
+	granularity time.Duration
+
+	mu    sync.Mutex
+	slots []Bucket
+}
+
+func NewBucketRecorder(granularity, retention time.Duration) *BucketRecorder {
+	n := int(retention / granularity)
+	if n < 1 {
+		n = 1
+	}
+	if n > maxBuckets {
+		n = maxBuckets
+	}
+	return &BucketRecorder{granularity: granularity, slots: make([]Bucket, n)}
+}
+
+func (b *BucketRecorder) slot(start time.Time) int {
+	return int((start.UnixNano() / int64(b.granularity)) % int64(len(b.slots)))
+}
+
+// Record adds one increment to the bucket containing now.
+func (b *BucketRecorder) Record(now time.Time) {
+	start := now.Truncate(b.granularity)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	slot := &b.slots[b.slot(start)]
+	if !slot.Start.Equal(start) {
+		// The slot still holds a bucket from a previous lap of the ring.
+		*slot = Bucket{Start: start}
+	}
+	slot.Count++
+}
+
+// Last returns the n most recent buckets up to and including the one
+// containing now, oldest first. Buckets with no increments are included with
+// a zero count.
+func (b *BucketRecorder) Last(n int, now time.Time) []Bucket {
+	if n > len(b.slots) {
+		n = len(b.slots)
+	}
+	current := now.Truncate(b.granularity)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	buckets := make([]Bucket, n)
+	for i := range buckets {
+		start := current.Add(-time.Duration(n-1-i) * b.granularity)
+		buckets[i] = Bucket{Start: start}
+		if slot := b.slots[b.slot(start)]; slot.Start.Equal(start) {
+			buckets[i].Count = slot.Count
+		}
+	}
+	return buckets
+}
+
+// Retention is how far back buckets are kept.
+func (b *BucketRecorder) Retention() time.Duration {
+	return time.Duration(len(b.slots)) * b.granularity
+}
+
+// BucketsHandler serves the recorded buckets covering the last ?hours=
+// (default: the whole retention).
+func BucketsHandler(b *BucketRecorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		window := b.Retention()
+		if raw := r.URL.Query().Get("hours"); raw != "" {
+			hours, err := strconv.Atoi(raw)
+			if err != nil || hours <= 0 {
+				writeJSON(w, http.StatusBadRequest, errorResponse{Message: "hours must be a positive integer"})
+				return
+			}
+			if requested := time.Duration(hours) * time.Hour; requested < window {
+				window = requested
+			}
+		}
+
+		n := int(window / b.granularity)
+		if n < 1 {
+			n = 1
+		}
+
+		buckets := b.Last(n, time.Now())
+		var total int64
+		for _, bucket := range buckets {
+			total += bucket.Count
+		}
+
+		writeJSON(w, http.StatusOK, struct {
+			Granularity string   `json:"granularity"`
+			Retention   string   `json:"retention"`
+			Total       int64    `json:"total"`
+			Buckets     []Bucket `json:"buckets"`
+		}{
+			Granularity: b.granularity.String(),
+			Retention:   b.Retention().String(),
+			Total:       total,
+			Buckets:     buckets,
+		})
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"math"
+	"strconv"
+)
+
+// countChecksum is the HMAC-SHA256 of a counters row. The counter name is
+// part of the message so a checksum cannot be copied between rows; names
+// cannot contain ':'.
+func countChecksum(key []byte, name string, count int64) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("counters:" + name + ":" + strconv.FormatInt(count, 10)))
+	return mac.Sum(nil)
+}
+
+// verifyChecksum reports a count whose checksum does not match, which means
+// the row was written by something that does not hold COUNT_HMAC_KEY. It
+// only logs and counts the mismatch; the value is still served.
+func (c *CockroachStore) verifyChecksum(name string, count int64, checksum []byte) {
+	if hmac.Equal(checksum, countChecksum(c.hmacKey, name, count)) {
+		return
+	}
+	checksumMismatchesTotal.Inc()
+	if checksum == nil {
+		slog.Error("Checksum mismatch: counter has no checksum. It was written without COUNT_HMAC_KEY or the checksum was cleared.", "counter", name, "count", count)
+		return
+	}
+	slog.Error("Checksum mismatch: count does not match its checksum. The count may have been modified out-of-band.", "counter", name, "count", count)
+}
+
+// ensureChecksumColumn adds the nullable checksum column once per process.
+func (c *CockroachStore) ensureChecksumColumn(ctx context.Context, conn *sql.Conn) error {
+	if c.checksumColumnReady.Load() {
+		return nil
+	}
+	if _, err := conn.ExecContext(ctx, `ALTER TABLE counters ADD COLUMN IF NOT EXISTS checksum BYTES`); err != nil {
+		return err
+	}
+	c.checksumColumnReady.Store(true)
+	return nil
+}
+
+// addSignedRow applies delta and re-signs the row in one transaction,
+// verifying the previous value on the way. The row is locked for the read so
+// concurrent increments cannot sign a stale value. A missing row is created
+// only with create; a concurrent create of the same row fails on the primary
+// key rather than losing an increment.
+func (c *CockroachStore) addSignedRow(ctx context.Context, conn *sql.Conn, name string, delta int64, create bool) (int64, error) {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var count int64
+	var checksum []byte
+	err = tx.QueryRowContext(ctx, `SELECT count, checksum FROM counters WHERE name = $1 FOR UPDATE`, name).Scan(&count, &checksum)
+	exists := err == nil
+	switch {
+	case exists:
+		c.verifyChecksum(name, count, checksum)
+	case errors.Is(err, sql.ErrNoRows) && create:
+	default:
+		return 0, err
+	}
+
+	// The sum is computed here rather than in SQL, so check the range as
+	// InMemoryStore.add does; a wrapped value would be signed as valid.
+	if (delta > 0 && count > math.MaxInt64-delta) || (delta < 0 && count < math.MinInt64-delta) {
+		return 0, errCountOverflow
+	}
+	count += delta
+	query := `UPDATE counters SET count = $2, checksum = $3 WHERE name = $1`
+	if !exists {
+		query = `INSERT INTO counters (name, count, checksum) VALUES ($1, $2, $3)`
+	}
+	_, err = tx.ExecContext(ctx, c.withQueryComment(ctx, query), name, count, countChecksum(c.hmacKey, name, count))
+	if err != nil {
+		return 0, err
+	}
+	return count, tx.Commit()
+}
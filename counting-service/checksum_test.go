@@ -0,0 +1,67 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestAddSignedRowRefusesOverflow(t *testing.T) {
+	key := []byte("test-key")
+	tests := []struct {
+		name  string
+		start int64
+		delta int64
+	}{
+		{"past MaxInt64", math.MaxInt64, 1},
+		{"weighted past MaxInt64", math.MaxInt64 - 2, 5},
+		{"past MinInt64", math.MinInt64, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newFakeDB()
+			db.setRow(defaultCounterName, tt.start, countChecksum(key, defaultCounterName, tt.start))
+			store := newFakeCockroachStore(t, db)
+			store.hmacKey = key
+
+			conn, err := store.db.Conn(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			if _, err := store.addSignedRow(context.Background(), conn, defaultCounterName, tt.delta, false); !errors.Is(err, errCountOverflow) {
+				t.Fatalf("addSignedRow(%d + %d) error = %v, want %v", tt.start, tt.delta, err, errCountOverflow)
+			}
+			if got := db.rows[defaultCounterName]; got != tt.start {
+				t.Errorf("row = %d after a refused increment, want %d", got, tt.start)
+			}
+		})
+	}
+}
+
+func TestAddSignedRowSignsNewValue(t *testing.T) {
+	key := []byte("test-key")
+	db := newFakeDB()
+	db.setRow(defaultCounterName, 41, countChecksum(key, defaultCounterName, 41))
+	store := newFakeCockroachStore(t, db)
+	store.hmacKey = key
+
+	conn, err := store.db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	count, err := store.addSignedRow(context.Background(), conn, defaultCounterName, 1, false)
+	if err != nil || count != 42 {
+		t.Fatalf("addSignedRow = %d, %v; want 42, nil", count, err)
+	}
+	if string(db.checksums[defaultCounterName]) != string(countChecksum(key, defaultCounterName, 42)) {
+		t.Error("row was not re-signed with the new count")
+	}
+}
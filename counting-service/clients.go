@@ -0,0 +1,167 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"container/list"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultClientTrackerSize = 1024
+const defaultClientTrackerWindow = 1 * time.Hour
+const defaultClientsLimit = 10
+
+// trustedProxyHops is the number of reverse proxies in front of the service,
+// from TRUSTED_PROXY_HOPS. It is set once at startup.
+var trustedProxyHops = 0
+
+// clientIP returns the address of the client that sent the request. With no
+// trusted proxies that is the peer address. Otherwise each trusted proxy
+// appended the address it received from to X-Forwarded-For, so the client is
+// the entry trustedProxyHops from the right; anything further left was
+// supplied by the client and cannot be trusted.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if trustedProxyHops <= 0 {
+		return host
+	}
+
+	// Proxies may append a new header instead of extending the existing one,
+	// so all X-Forwarded-For headers are read as one chain.
+	var chain []string
+	for _, value := range r.Header.Values("X-Forwarded-For") {
+		for _, entry := range strings.Split(value, ",") {
+			if entry = strings.TrimSpace(entry); entry != "" {
+				chain = append(chain, entry)
+			}
+		}
+	}
+	// A shorter chain means the request skipped some proxies, so any entry
+	// may have been supplied by the client itself.
+	if len(chain) < trustedProxyHops {
+		return host
+	}
+	if ip := net.ParseIP(chain[len(chain)-trustedProxyHops]); ip != nil {
+		return ip.String()
+	}
+	return host
+}
+
+// ClientStats summarises the requests seen from one client IP during the
+// current window.
+type ClientStats struct {
+	IP        string    `json:"ip"`
+	Hits      int64     `json:"hits"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// ClientTracker keeps hit counts for the most recently seen client IPs. It
+// holds at most size entries, evicting the least recently seen IP first, and
+// restarts an IP's count once its window has elapsed.
+type ClientTracker struct {
+	mu      sync.Mutex
+	size    int
+	window  time.Duration
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+func NewClientTracker(size int, window time.Duration) *ClientTracker {
+	return &ClientTracker{
+		size:    size,
+		window:  window,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+func (t *ClientTracker) Record(ip string, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if elem, ok := t.entries[ip]; ok {
+		stats := elem.Value.(*ClientStats)
+		if now.Sub(stats.FirstSeen) > t.window {
+			stats.Hits = 0
+			stats.FirstSeen = now
+		}
+		stats.Hits++
+		stats.LastSeen = now
+		t.lru.MoveToFront(elem)
+		return
+	}
+
+	t.entries[ip] = t.lru.PushFront(&ClientStats{IP: ip, Hits: 1, FirstSeen: now, LastSeen: now})
+	if t.lru.Len() > t.size {
+		oldest := t.lru.Back()
+		t.lru.Remove(oldest)
+		delete(t.entries, oldest.Value.(*ClientStats).IP)
+	}
+}
+
+// Top returns up to n clients active within the window, busiest first.
+func (t *ClientTracker) Top(n int, now time.Time) []ClientStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	top := make([]ClientStats, 0, t.lru.Len())
+	for elem := t.lru.Front(); elem != nil; elem = elem.Next() {
+		stats := elem.Value.(*ClientStats)
+		if now.Sub(stats.LastSeen) > t.window {
+			// The list is ordered by recency, so the rest are older still.
+			break
+		}
+		top = append(top, *stats)
+	}
+
+	sort.Slice(top, func(i, j int) bool {
+		return top[i].Hits > top[j].Hits
+	})
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+// Middleware records the client IP of every request it serves.
+func (t *ClientTracker) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Record(clientIP(r), time.Now())
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ClientsHandler lists the busiest recent client IPs. The number returned
+// defaults to 10 and can be set with the limit query parameter.
+func ClientsHandler(t *ClientTracker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit := defaultClientsLimit
+		if raw := r.URL.Query().Get("limit"); raw != "" {
+			parsed, err := strconv.Atoi(raw)
+			if err != nil || parsed <= 0 {
+				writeJSON(w, http.StatusBadRequest, errorResponse{Message: "limit must be a positive integer"})
+				return
+			}
+			limit = parsed
+		}
+
+		writeJSON(w, http.StatusOK, struct {
+			Window  string        `json:"window"`
+			Clients []ClientStats `json:"clients"`
+		}{
+			Window:  t.window.String(),
+			Clients: t.Top(limit, time.Now()),
+		})
+	}
+}
@@ -0,0 +1,41 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name string
+		hops int
+		xff  []string
+		want string
+	}{
+		{"no trusted proxies ignores the header", 0, []string{"203.0.113.9"}, "192.0.2.1"},
+		{"no header", 1, nil, "192.0.2.1"},
+		{"one hop", 1, []string{"203.0.113.9"}, "203.0.113.9"},
+		{"spoofed entries left of the trusted hop", 1, []string{"198.51.100.7, 203.0.113.9"}, "203.0.113.9"},
+		{"two hops across repeated headers", 2, []string{"198.51.100.7, 203.0.113.9", "10.0.0.2"}, "203.0.113.9"},
+		{"chain shorter than the hops", 2, []string{"198.51.100.7"}, "192.0.2.1"},
+		{"unparseable entry", 1, []string{"not-an-ip"}, "192.0.2.1"},
+	}
+	defer func(hops int) { trustedProxyHops = hops }(trustedProxyHops)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trustedProxyHops = tt.hops
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = "192.0.2.1:52000"
+			for _, value := range tt.xff {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if got := clientIP(r); got != tt.want {
+				t.Errorf("clientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
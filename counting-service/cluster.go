@@ -0,0 +1,145 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultPeerTimeout = 1 * time.Second
+
+// maxPeerResponseBytes bounds how much of a peer's /count response is read.
+const maxPeerResponseBytes = 64 << 10
+
+// InstanceCount is one instance's view of the default counter in a /cluster
+// response. Error is set instead of Count when the instance could not be
+// read.
+type InstanceCount struct {
+	URL      string `json:"url,omitempty"`
+	Hostname string `json:"hostname,omitempty"`
+	Count    *int64 `json:"count,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+type clusterResponse struct {
+	Self  InstanceCount   `json:"self"`
+	Peers []InstanceCount `json:"peers"`
+	// Divergent is true when the instances that answered disagree. Spread
+	// is the difference between the highest and lowest of their counts.
+	Divergent bool  `json:"divergent"`
+	Spread    int64 `json:"spread"`
+}
+
+// parsePeers splits PEERS into base URLs, dropping empty entries and
+// trailing slashes.
+func parsePeers(raw string) []string {
+	var peers []string
+	for _, peer := range strings.Split(raw, ",") {
+		peer = strings.TrimRight(strings.TrimSpace(peer), "/")
+		if peer != "" {
+			peers = append(peers, peer)
+		}
+	}
+	return peers
+}
+
+// fetchPeerCount reads a peer's default counter from its /count route, which
+// never increments.
+func fetchPeerCount(ctx context.Context, client *http.Client, peer string) InstanceCount {
+	result := InstanceCount{URL: peer}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, peer+"/count", nil)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxPeerResponseBytes))
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	var count Count
+	if err := json.Unmarshal(body, &count); err != nil {
+		result.Error = fmt.Sprintf("unexpected response (status %d): %v", resp.StatusCode, err)
+		return result
+	}
+	result.Hostname = count.Hostname
+	if resp.StatusCode != http.StatusOK {
+		result.Error = fmt.Sprintf("status %d: %s", resp.StatusCode, count.Message)
+		return result
+	}
+	result.Count = &count.Count
+	return result
+}
+
+// ClusterHandler compares this instance's count with each peer's, for
+// deployments without a shared store where instances can drift apart.
+// Peers are queried concurrently; one that fails or times out is reported
+// with an error and left out of the divergence check.
+func ClusterHandler(store CounterStore, timeout time.Duration, peers []string, peerTimeout time.Duration) http.HandlerFunc {
+	client := &http.Client{Timeout: peerTimeout}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		hostname, _ := os.Hostname()
+		response := clusterResponse{
+			Self:  InstanceCount{Hostname: hostname},
+			Peers: make([]InstanceCount, len(peers)),
+		}
+
+		var wg sync.WaitGroup
+		for i, peer := range peers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				response.Peers[i] = fetchPeerCount(r.Context(), client, peer)
+			}()
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		count, err := store.GetCount(ctx, defaultCounterName)
+		cancel()
+		if err != nil {
+			response.Self.Error = fmt.Sprintf("DB Error: %v", err)
+		} else {
+			response.Self.Count = &count
+		}
+		wg.Wait()
+
+		var low, high int64
+		seen := false
+		for _, instance := range append([]InstanceCount{response.Self}, response.Peers...) {
+			if instance.Count == nil {
+				continue
+			}
+			if !seen || *instance.Count < low {
+				low = *instance.Count
+			}
+			if !seen || *instance.Count > high {
+				high = *instance.Count
+			}
+			seen = true
+		}
+		response.Spread = high - low
+		response.Divergent = response.Spread != 0
+
+		writeJSON(w, http.StatusOK, response)
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+const defaultCORSAllowOrigin = "*"
+
+const (
+	corsAllowMethods = "GET, HEAD, POST, DELETE, OPTIONS"
+	// corsExposeHeaders are the response headers browser code may read.
+	corsExposeHeaders = "X-Request-ID, X-Count, X-Count-Sequence, X-Count-Token, X-Poll-Timeout, X-Storage-Mode, Retry-After"
+	corsMaxAge        = "600"
+)
+
+// cors lets browser dashboards on other origins call the API. allowOrigins
+// is "*" or a list of origins; a listed origin is echoed back so responses
+// can vary by it. Every OPTIONS request is answered here with 204, so a
+// preflight never reaches a handler or the store.
+func cors(allowOrigins []string, next http.Handler) http.Handler {
+	allowAny := slices.Contains(allowOrigins, "*")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		allowed := ""
+		switch {
+		case allowAny:
+			allowed = "*"
+		case origin != "" && slices.Contains(allowOrigins, origin):
+			allowed = origin
+		}
+		if !allowAny {
+			w.Header().Add("Vary", "Origin")
+		}
+
+		if allowed != "" {
+			w.Header().Set("Access-Control-Allow-Origin", allowed)
+			w.Header().Set("Access-Control-Expose-Headers", corsExposeHeaders)
+		}
+
+		if r.Method != http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if allowed != "" {
+			w.Header().Set("Access-Control-Allow-Methods", corsAllowMethods)
+			if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+				w.Header().Set("Access-Control-Allow-Headers", headers)
+			}
+			w.Header().Set("Access-Control-Max-Age", corsMaxAge)
+		}
+		w.Header().Set("Allow", corsAllowMethods)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// parseCORSOrigins splits CORS_ALLOW_ORIGIN on commas, dropping empty
+// entries and trailing slashes.
+func parseCORSOrigins(raw string) []string {
+	var origins []string
+	for _, origin := range strings.Split(raw, ",") {
+		origin = strings.TrimRight(strings.TrimSpace(origin), "/")
+		if origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	if len(origins) == 0 {
+		return []string{defaultCORSAllowOrigin}
+	}
+	return origins
+}
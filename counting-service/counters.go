@@ -0,0 +1,87 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// defaultCounterName is the counter served on / and used by the badge, the
+// DNS listener, the watchdog and the heartbeat.
+const defaultCounterName = "default"
+
+const defaultMaxCounterNameLen = 128
+
+// maxCounterNameLen bounds /counter/{name}, from MAX_COUNTER_NAME_LEN. It is
+// set once at startup.
+var maxCounterNameLen = defaultMaxCounterNameLen
+
+// allowedCounters, from ALLOWED_COUNTERS, limits named counters to a fixed
+// set so clients cannot create them without bound. Nil allows any valid
+// name. It is set once at startup.
+var allowedCounters map[string]bool
+
+// parseAllowedCounters parses ALLOWED_COUNTERS, a comma-separated list of
+// counter names. Invalid names are skipped with a warning. Empty means nil.
+func parseAllowedCounters(raw string) map[string]bool {
+	var allowed map[string]bool
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if err := validateCounterName(name); err != nil {
+			slog.Warn("Invalid ALLOWED_COUNTERS entry. Skipping.", "entry", name, "error", err)
+			continue
+		}
+		if allowed == nil {
+			allowed = make(map[string]bool)
+		}
+		allowed[name] = true
+	}
+	return allowed
+}
+
+// counterName returns the counter a request addresses: the {name} route
+// variable, or defaultCounterName on routes without one. named reports
+// whether the name came from the path.
+func counterName(r *http.Request) (name string, named bool, err error) {
+	name, named = mux.Vars(r)["name"]
+	if !named {
+		return defaultCounterName, false, nil
+	}
+	if err := validateCounterName(name); err != nil {
+		return "", true, err
+	}
+	return name, true, nil
+}
+
+// validateCounterName allows only letters, digits, '.', '_' and '-', so names
+// are safe in URLs, logs and checksums. With ALLOWED_COUNTERS set, only the
+// listed names and defaultCounterName are valid.
+func validateCounterName(name string) error {
+	if name == "" {
+		return fmt.Errorf("counter name must not be empty")
+	}
+	if len(name) > maxCounterNameLen {
+		return fmt.Errorf("counter name is longer than %d characters", maxCounterNameLen)
+	}
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9',
+			r == '.', r == '_', r == '-':
+		default:
+			return fmt.Errorf("counter name %q may only contain letters, digits, '.', '_' and '-'", name)
+		}
+	}
+	if allowedCounters != nil && !allowedCounters[name] && name != defaultCounterName {
+		return fmt.Errorf("counter %q is not in ALLOWED_COUNTERS", name)
+	}
+	return nil
+}
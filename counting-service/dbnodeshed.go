@@ -0,0 +1,85 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"log/slog"
+	"sync/atomic"
+)
+
+// dbNodeResumeRatio is how far below its threshold load must fall before
+// db_node lookups resume, so the mode does not flap at the boundary.
+const dbNodeResumeRatio = 0.75
+
+// PoolStatser is implemented by stores with a connection pool whose
+// utilization can be read.
+type PoolStatser interface {
+	PoolUtilization() (inUse, maxOpen int)
+}
+
+// DBNodeShedder skips the optional db_node lookup while the service is under
+// load, leaving DB connections to increments. Load is the number of in-flight
+// count requests and, when the pool is bounded, the share of its connections
+// in use.
+type DBNodeShedder struct {
+	inFlightLimit int64
+	poolLimit     float64 // fraction of the pool; 0 disables
+	pool          PoolStatser
+
+	inFlight atomic.Int64
+	shedding atomic.Bool
+}
+
+// NewDBNodeShedder sheds at inFlightLimit concurrent requests or at
+// poolPercent of pool in use; a zero limit or nil pool disables that check.
+func NewDBNodeShedder(inFlightLimit int64, poolPercent int, pool PoolStatser) *DBNodeShedder {
+	return &DBNodeShedder{
+		inFlightLimit: inFlightLimit,
+		poolLimit:     float64(poolPercent) / 100,
+		pool:          pool,
+	}
+}
+
+// Begin counts a request as in flight until the returned func is called. A
+// nil shedder counts nothing.
+func (s *DBNodeShedder) Begin() func() {
+	if s == nil {
+		return func() {}
+	}
+	s.inFlight.Add(1)
+	return func() { s.inFlight.Add(-1) }
+}
+
+// Allow reports whether a db_node lookup is affordable right now. A nil
+// shedder always allows it.
+func (s *DBNodeShedder) Allow() bool {
+	if s == nil {
+		return true
+	}
+
+	load := s.load()
+	if s.shedding.Load() {
+		if load < dbNodeResumeRatio && s.shedding.CompareAndSwap(true, false) {
+			slog.Info("Load subsided; resuming db_node lookups", "in_flight", s.inFlight.Load())
+		}
+	} else if load >= 1 && s.shedding.CompareAndSwap(false, true) {
+		slog.Warn("Under load; skipping db_node lookups", "in_flight", s.inFlight.Load())
+	}
+	return !s.shedding.Load()
+}
+
+// load is the highest ratio of any measure to its threshold, so 1 or more
+// means at least one threshold is reached.
+func (s *DBNodeShedder) load() float64 {
+	var load float64
+	if s.inFlightLimit > 0 {
+		load = float64(s.inFlight.Load()) / float64(s.inFlightLimit)
+	}
+	if s.poolLimit > 0 && s.pool != nil {
+		if inUse, maxOpen := s.pool.PoolUtilization(); maxOpen > 0 {
+			load = max(load, float64(inUse)/float64(maxOpen)/s.poolLimit)
+		}
+	}
+	return load
+}
@@ -0,0 +1,71 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+const defaultDNSCacheTTL = 30 * time.Second
+
+// lookupIPFunc resolves a host, like net.LookupIP.
+type lookupIPFunc func(host string) ([]net.IP, error)
+
+// dnsServerIPs caches resolutions of the DNS_SERVER host. main replaces it
+// with one using DNS_CACHE_TTL_MS.
+var dnsServerIPs = newIPCache(net.LookupIP, defaultDNSCacheTTL)
+
+// ipCache reuses successful lookups of a host for ttl, so resolving the same
+// host again, for example to re-check DNS_SERVER for failover, does not cost
+// a round trip each time. Failures are not cached. A zero ttl disables
+// caching.
+type ipCache struct {
+	lookup lookupIPFunc
+	ttl    time.Duration
+	now    func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]ipCacheEntry
+}
+
+type ipCacheEntry struct {
+	ips     []net.IP
+	expires time.Time
+}
+
+// newIPCache wraps lookup, which tests can replace with a fake resolver.
+func newIPCache(lookup lookupIPFunc, ttl time.Duration) *ipCache {
+	return &ipCache{
+		lookup:  lookup,
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[string]ipCacheEntry),
+	}
+}
+
+// LookupIP returns host's addresses, from the cache while they are fresh.
+func (c *ipCache) LookupIP(host string) ([]net.IP, error) {
+	now := c.now()
+
+	c.mu.Lock()
+	entry, ok := c.entries[host]
+	c.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		slog.Debug("DNS cache hit", "host", host, "expires_in", entry.expires.Sub(now))
+		return entry.ips, nil
+	}
+
+	ips, err := c.lookup(host)
+	if err != nil || len(ips) == 0 || c.ttl <= 0 {
+		return ips, err
+	}
+
+	c.mu.Lock()
+	c.entries[host] = ipCacheEntry{ips: ips, expires: now.Add(c.ttl)}
+	c.mu.Unlock()
+	return ips, nil
+}
@@ -0,0 +1,92 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// countingResolver is a fake lookupIPFunc that records how often each host
+// was resolved and fails while err is set.
+type countingResolver struct {
+	calls map[string]int
+	err   error
+}
+
+func (r *countingResolver) lookup(host string) ([]net.IP, error) {
+	r.calls[host]++
+	if r.err != nil {
+		return nil, r.err
+	}
+	return []net.IP{net.ParseIP("10.0.0.1")}, nil
+}
+
+// newTestIPCache returns a cache over a fresh countingResolver whose clock
+// is under the test's control.
+func newTestIPCache(ttl time.Duration) (*ipCache, *countingResolver, *time.Time) {
+	resolver := &countingResolver{calls: make(map[string]int)}
+	cache := newIPCache(resolver.lookup, ttl)
+	now := time.Unix(1_700_000_000, 0)
+	cache.now = func() time.Time { return now }
+	return cache, resolver, &now
+}
+
+func TestIPCacheHitWithinTTL(t *testing.T) {
+	cache, resolver, now := newTestIPCache(30 * time.Second)
+
+	for i := 0; i < 3; i++ {
+		ips, err := cache.LookupIP("consul")
+		if err != nil || len(ips) != 1 {
+			t.Fatalf("LookupIP = %v, %v", ips, err)
+		}
+		*now = now.Add(10 * time.Second)
+	}
+	if got := resolver.calls["consul"]; got != 1 {
+		t.Errorf("resolved %d times within the TTL, want 1", got)
+	}
+	if _, _ = cache.LookupIP("other"); resolver.calls["other"] != 1 {
+		t.Errorf("a different host was served from the cache")
+	}
+}
+
+func TestIPCacheMissAfterTTL(t *testing.T) {
+	cache, resolver, now := newTestIPCache(30 * time.Second)
+
+	_, _ = cache.LookupIP("consul")
+	*now = now.Add(30 * time.Second)
+	_, _ = cache.LookupIP("consul")
+	if got := resolver.calls["consul"]; got != 2 {
+		t.Errorf("resolved %d times, want 2 once the entry expired", got)
+	}
+}
+
+func TestIPCacheDoesNotCacheFailures(t *testing.T) {
+	cache, resolver, _ := newTestIPCache(30 * time.Second)
+	resolver.err = errors.New("no such host")
+
+	if _, err := cache.LookupIP("consul"); err == nil {
+		t.Fatal("LookupIP succeeded while the resolver was failing")
+	}
+	resolver.err = nil
+	if _, err := cache.LookupIP("consul"); err != nil {
+		t.Fatalf("LookupIP after recovery: %v", err)
+	}
+	if got := resolver.calls["consul"]; got != 2 {
+		t.Errorf("resolved %d times, want 2: the failure must not be cached", got)
+	}
+}
+
+func TestIPCacheZeroTTLDisablesCaching(t *testing.T) {
+	cache, resolver, _ := newTestIPCache(0)
+
+	for i := 0; i < 3; i++ {
+		_, _ = cache.LookupIP("consul")
+	}
+	if got := resolver.calls["consul"]; got != 3 {
+		t.Errorf("resolved %d times with caching disabled, want 3", got)
+	}
+}
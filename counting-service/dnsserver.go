@@ -0,0 +1,141 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const defaultDNSServeName = "count."
+
+// maxDNSQueriesInFlight bounds how many queries are answered at once. Past
+// it, Serve stops reading until one finishes, and further packets queue in
+// the socket buffer or are dropped by the kernel.
+const maxDNSQueriesInFlight = 64
+
+// DNSCountServer answers DNS TXT queries for a single configured name with
+// the current count, for tooling that can only perform DNS lookups. Every
+// other query is answered with NXDOMAIN.
+type DNSCountServer struct {
+	store   CounterStore
+	name    string
+	timeout time.Duration
+}
+
+func NewDNSCountServer(store CounterStore, name string, timeout time.Duration) *DNSCountServer {
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	return &DNSCountServer{store: store, name: strings.ToLower(name), timeout: timeout}
+}
+
+// Serve answers queries received on conn until it is closed, each in its own
+// goroutine so a slow store read does not hold up the rest. It returns once
+// the queries in flight have been answered.
+func (s *DNSCountServer) Serve(conn net.PacketConn) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	sem := make(chan struct{}, maxDNSQueriesInFlight)
+	for {
+		buf := make([]byte, 512)
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			s.answer(conn, buf[:n], addr)
+		}()
+	}
+}
+
+func (s *DNSCountServer) answer(conn net.PacketConn, query []byte, addr net.Addr) {
+	resp, err := s.handle(query)
+	if err != nil {
+		slog.Warn("Dropping malformed DNS query", "addr", addr.String(), "error", err)
+		return
+	}
+	if _, err := conn.WriteTo(resp, addr); err != nil && !errors.Is(err, net.ErrClosed) {
+		slog.Warn("DNS write failed", "addr", addr.String(), "error", err)
+	}
+}
+
+func (s *DNSCountServer) handle(query []byte) ([]byte, error) {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(query)
+	if err != nil {
+		return nil, err
+	}
+	questions, err := parser.AllQuestions()
+	if err != nil {
+		return nil, err
+	}
+
+	rcode := dnsmessage.RCodeNameError
+	var answer string
+	if len(questions) == 1 && questions[0].Type == dnsmessage.TypeTXT &&
+		questions[0].Class == dnsmessage.ClassINET &&
+		strings.ToLower(questions[0].Name.String()) == s.name {
+		ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+		count, err := s.store.GetCount(ctx, defaultCounterName)
+		cancel()
+		if err != nil {
+			slog.Error("DNS TXT lookup failed to read count", "error", err)
+			rcode = dnsmessage.RCodeServerFailure
+		} else {
+			rcode = dnsmessage.RCodeSuccess
+			answer = strconv.FormatInt(count, 10)
+		}
+	}
+
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{
+		ID:               header.ID,
+		Response:         true,
+		OpCode:           header.OpCode,
+		Authoritative:    true,
+		RecursionDesired: header.RecursionDesired,
+		RCode:            rcode,
+	})
+	builder.EnableCompression()
+	if err := builder.StartQuestions(); err != nil {
+		return nil, err
+	}
+	for _, q := range questions {
+		if err := builder.Question(q); err != nil {
+			return nil, err
+		}
+	}
+	if rcode == dnsmessage.RCodeSuccess {
+		if err := builder.StartAnswers(); err != nil {
+			return nil, err
+		}
+		err := builder.TXTResource(dnsmessage.ResourceHeader{
+			Name:  questions[0].Name,
+			Class: dnsmessage.ClassINET,
+		}, dnsmessage.TXTResource{TXT: []string{answer}})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return builder.Finish()
+}
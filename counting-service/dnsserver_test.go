@@ -0,0 +1,105 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// blockingStore holds every GetCount until release is closed, reporting each
+// call on started.
+type blockingStore struct {
+	*InMemoryStore
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s blockingStore) GetCount(ctx context.Context, name string) (int64, error) {
+	s.started <- struct{}{}
+	<-s.release
+	return s.InMemoryStore.GetCount(ctx, name)
+}
+
+func dnsTXTQuery(t *testing.T, id uint16, name string) []byte {
+	t.Helper()
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id})
+	if err := builder.StartQuestions(); err != nil {
+		t.Fatal(err)
+	}
+	err := builder.Question(dnsmessage.Question{
+		Name:  dnsmessage.MustNewName(name),
+		Type:  dnsmessage.TypeTXT,
+		Class: dnsmessage.ClassINET,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	query, err := builder.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return query
+}
+
+func TestDNSServerAnswersQueriesConcurrently(t *testing.T) {
+	store := blockingStore{NewInMemoryStore(7), make(chan struct{}), make(chan struct{})}
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error)
+	go func() { served <- NewDNSCountServer(store, "count", time.Second).Serve(conn) }()
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	for id := uint16(1); id <= 2; id++ {
+		if _, err := client.Write(dnsTXTQuery(t, id, "count.")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Both queries reach the store while neither has been answered.
+	for i := 0; i < 2; i++ {
+		select {
+		case <-store.started:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%d of 2 queries reached the store", i)
+		}
+	}
+	close(store.release)
+
+	buf := make([]byte, 512)
+	for i := 0; i < 2; i++ {
+		client.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, err := client.Read(buf)
+		if err != nil {
+			t.Fatalf("reading answer %d: %v", i+1, err)
+		}
+		var msg dnsmessage.Message
+		if err := msg.Unpack(buf[:n]); err != nil {
+			t.Fatal(err)
+		}
+		if len(msg.Answers) != 1 || msg.Answers[0].Body.(*dnsmessage.TXTResource).TXT[0] != "7" {
+			t.Errorf("answer %d = %v, want TXT 7", msg.ID, msg.Answers)
+		}
+	}
+
+	conn.Close()
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("Serve returned %v after close, want nil", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Serve did not return after the conn was closed")
+	}
+}
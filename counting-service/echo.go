@@ -0,0 +1,95 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+)
+
+// echoHeaders are the request headers /echo reports: the ones proxies add or
+// rewrite, and the ones auth and CORS decisions depend on. Others are left
+// out so the response does not grow with whatever a client happens to send.
+var echoHeaders = []string{
+	"Accept",
+	"Accept-Encoding",
+	"Access-Control-Request-Headers",
+	"Access-Control-Request-Method",
+	"Authorization",
+	"Content-Length",
+	"Content-Type",
+	"Cookie",
+	"Forwarded",
+	"Origin",
+	"Referer",
+	"Traceparent",
+	"User-Agent",
+	"Via",
+	"X-Forwarded-For",
+	"X-Forwarded-Host",
+	"X-Forwarded-Proto",
+	"X-Real-Ip",
+	"X-Request-Id",
+}
+
+// redactedValue replaces the values of credential headers, so /echo shows
+// that one arrived without showing what it was.
+const redactedValue = "[redacted]"
+
+// echoResponse is what /echo saw of a request after every proxy in front of
+// the service had its way with it.
+type echoResponse struct {
+	Method     string              `json:"method"`
+	Path       string              `json:"path"`
+	Query      url.Values          `json:"query"`
+	Host       string              `json:"host"` // not in r.Header, so reported separately
+	RemoteAddr string              `json:"remote_addr"`
+	ClientIP   string              `json:"client_ip"`
+	Headers    map[string][]string `json:"headers"`
+}
+
+// EchoHandler describes the request it received, for debugging proxy header
+// rewriting. Authorization, Cookie and the extra redact headers, such as
+// REQUIRED_HEADER_NAME, are redacted.
+func EchoHandler(redact ...string) http.HandlerFunc {
+	redacted := map[string]bool{"Authorization": true, "Cookie": true}
+	for _, name := range redact {
+		if name != "" {
+			redacted[http.CanonicalHeaderKey(name)] = true
+		}
+	}
+	names := append([]string(nil), echoHeaders...)
+	for name := range redacted {
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		headers := make(map[string][]string)
+		for _, name := range names {
+			values := r.Header.Values(name)
+			if len(values) == 0 {
+				continue
+			}
+			if redacted[name] {
+				values = make([]string, len(values))
+				for i := range values {
+					values[i] = redactedValue
+				}
+			}
+			headers[name] = values
+		}
+		writeJSON(w, http.StatusOK, echoResponse{
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Query:      r.URL.Query(),
+			Host:       r.Host,
+			RemoteAddr: r.RemoteAddr,
+			ClientIP:   clientIP(r),
+			Headers:    headers,
+		})
+	}
+}
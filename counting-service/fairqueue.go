@@ -0,0 +1,170 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const defaultFairQueueDepth = 256
+const defaultFairQueuePerClient = 16
+const defaultFairQueueTimeout = 500 * time.Millisecond
+
+var errQueueFull = errors.New("request queue is full")
+var errQueueTimeout = errors.New("timed out waiting in the request queue")
+
+// FairQueue limits how many requests reach the store at once. Requests over
+// the limit wait in per-client FIFO queues, and freed slots are handed to
+// clients in round-robin order, so a client sending many requests only ever
+// gets its turn instead of starving everyone queued behind it.
+type FairQueue struct {
+	concurrency int
+	maxDepth    int
+	perClient   int
+	timeout     time.Duration
+
+	mu       sync.Mutex
+	inUse    int
+	depth    int
+	waiting  map[string]*clientQueue
+	rotation *list.List // clients with waiters, next to serve first
+}
+
+type clientQueue struct {
+	waiters  *list.List // *queueWaiter, oldest first
+	rotation *list.Element
+}
+
+type queueWaiter struct {
+	client  string
+	ready   chan struct{}
+	granted bool
+	elem    *list.Element
+}
+
+func NewFairQueue(concurrency, maxDepth, perClient int, timeout time.Duration) *FairQueue {
+	return &FairQueue{
+		concurrency: concurrency,
+		maxDepth:    maxDepth,
+		perClient:   perClient,
+		timeout:     timeout,
+		waiting:     make(map[string]*clientQueue),
+		rotation:    list.New(),
+	}
+}
+
+// acquire waits for a slot for client. Each successful acquire must be
+// paired with a release.
+func (q *FairQueue) acquire(ctx context.Context, client string) error {
+	q.mu.Lock()
+	if q.inUse < q.concurrency && q.depth == 0 {
+		q.inUse++
+		q.mu.Unlock()
+		return nil
+	}
+	if q.depth >= q.maxDepth {
+		q.mu.Unlock()
+		return errQueueFull
+	}
+	cq := q.waiting[client]
+	if cq == nil {
+		cq = &clientQueue{waiters: list.New(), rotation: q.rotation.PushBack(client)}
+		q.waiting[client] = cq
+	}
+	if cq.waiters.Len() >= q.perClient {
+		q.mu.Unlock()
+		return errQueueFull
+	}
+	w := &queueWaiter{client: client, ready: make(chan struct{})}
+	w.elem = cq.waiters.PushBack(w)
+	q.depth++
+	q.mu.Unlock()
+
+	timer := time.NewTimer(q.timeout)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case <-w.ready:
+		return nil
+	case <-timer.C:
+		err = errQueueTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if w.granted {
+		// The slot was handed over while we were giving up; use it.
+		return nil
+	}
+	q.remove(w)
+	return err
+}
+
+// release frees a slot, handing it straight to the next client in rotation
+// if any are waiting.
+func (q *FairQueue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	front := q.rotation.Front()
+	if front == nil {
+		q.inUse--
+		return
+	}
+
+	cq := q.waiting[front.Value.(string)]
+	w := cq.waiters.Front().Value.(*queueWaiter)
+	q.remove(w)
+	if cq.waiters.Len() > 0 {
+		// Send the client to the back so others are served first.
+		q.rotation.MoveToBack(cq.rotation)
+	}
+	w.granted = true
+	close(w.ready)
+}
+
+// remove drops w from its client's queue. The caller holds q.mu.
+func (q *FairQueue) remove(w *queueWaiter) {
+	cq := q.waiting[w.client]
+	cq.waiters.Remove(w.elem)
+	q.depth--
+	if cq.waiters.Len() == 0 {
+		delete(q.waiting, w.client)
+		q.rotation.Remove(cq.rotation)
+	}
+}
+
+// Middleware queues requests by client IP. HEAD requests never reach the
+// store, so they skip the queue.
+func (q *FairQueue) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if err := q.acquire(r.Context(), clientIP(r)); err != nil {
+			reason := "timeout"
+			if errors.Is(err, errQueueFull) {
+				reason = "full"
+			}
+			fairQueueRejectionsTotal.Inc(reason)
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(q.timeout)))
+			writeJSON(w, http.StatusServiceUnavailable, errorResponse{Message: err.Error()})
+			return
+		}
+		defer q.release()
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,226 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// fakeDB is an in-process stand-in for CockroachDB that understands just the
+// statements CockroachStore issues for the counters table. It records how
+// often each kind of statement ran, and lets a test inject failures.
+type fakeDB struct {
+	mu          sync.Mutex
+	tableExists bool
+	rows        map[string]int64
+	checksums   map[string][]byte
+	statements  map[string]int // by statementKind
+	seeded      int            // seed statements that inserted a row
+
+	// beforeStatement, if set, runs before each statement. A non-nil error
+	// fails the statement without applying it.
+	beforeStatement func(kind string) error
+}
+
+func newFakeDB() *fakeDB {
+	return &fakeDB{rows: make(map[string]int64), checksums: make(map[string][]byte), statements: make(map[string]int)}
+}
+
+// newFakeCockroachStore returns a CockroachStore backed by db, configured
+// like a default deployment.
+func newFakeCockroachStore(t *testing.T, db *fakeDB) *CockroachStore {
+	t.Helper()
+	pool := sql.OpenDB(fakeConnector{db})
+	t.Cleanup(func() { pool.Close() })
+	return &CockroachStore{
+		db:                 pool,
+		readDB:             pool,
+		connAcquireTimeout: time.Second,
+		recreateMissingRow: true,
+		bootstrapSem:       make(chan struct{}, 1),
+		bootstrapRetries:   defaultBootstrapRetries,
+		maxRetries:         defaultDBMaxRetries,
+		retryBase:          time.Millisecond,
+	}
+}
+
+// count returns how many times statements of kind ran successfully.
+func (db *fakeDB) count(kind string) int {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.statements[kind]
+}
+
+// setRow writes a row directly, as an operator or another writer would.
+func (db *fakeDB) setRow(name string, count int64, checksum []byte) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.tableExists = true
+	db.rows[name] = count
+	db.checksums[name] = checksum
+}
+
+func (db *fakeDB) deleteRow(name string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	delete(db.rows, name)
+}
+
+// statementKind classifies a query by the CockroachStore statement it is.
+func statementKind(query string) string {
+	switch q := strings.Join(strings.Fields(query), " "); {
+	case strings.HasPrefix(q, "CREATE TABLE IF NOT EXISTS counters"):
+		return "create_table"
+	case strings.HasPrefix(q, "SELECT count FROM counts"):
+		return "legacy_select"
+	case strings.HasPrefix(q, "INSERT INTO counters") && strings.Contains(q, "DO NOTHING"):
+		return "seed"
+	case strings.HasPrefix(q, "INSERT INTO counters") && strings.Contains(q, "DO UPDATE"):
+		return "upsert"
+	case strings.HasPrefix(q, "INSERT INTO counters (name, count, checksum)"):
+		return "insert_signed"
+	case strings.HasPrefix(q, "UPDATE counters SET count = $2, checksum = $3"):
+		return "update_signed"
+	case strings.HasPrefix(q, "SELECT count, checksum FROM counters"):
+		return "select_signed"
+	case strings.HasPrefix(q, "UPDATE counters"):
+		return "update"
+	case strings.HasPrefix(q, "SELECT count FROM counters"):
+		return "select"
+	default:
+		return "unknown: " + q
+	}
+}
+
+func (db *fakeDB) run(kind string, args []driver.NamedValue) (rows [][]driver.Value, affected int64, err error) {
+	if db.beforeStatement != nil {
+		if err := db.beforeStatement(kind); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if kind != "create_table" && kind != "legacy_select" && !db.tableExists {
+		return nil, 0, &pgconn.PgError{Code: "42P01", Message: `relation "counters" does not exist`}
+	}
+
+	switch kind {
+	case "create_table":
+		db.tableExists = true
+	case "legacy_select":
+		return nil, 0, &pgconn.PgError{Code: "42P01", Message: `relation "counts" does not exist`}
+	case "seed":
+		name, count := args[0].Value.(string), args[1].Value.(int64)
+		if _, ok := db.rows[name]; !ok {
+			db.rows[name] = count
+			db.seeded++
+			affected = 1
+		}
+	case "upsert":
+		name, delta := args[0].Value.(string), args[1].Value.(int64)
+		db.rows[name] += delta
+		rows = [][]driver.Value{{db.rows[name]}}
+	case "update":
+		name, delta := args[0].Value.(string), args[1].Value.(int64)
+		if current, ok := db.rows[name]; ok {
+			db.rows[name] = current + delta
+			rows = [][]driver.Value{{db.rows[name]}}
+		}
+	case "insert_signed", "update_signed":
+		name := args[0].Value.(string)
+		if _, ok := db.rows[name]; ok == (kind == "insert_signed") {
+			return nil, 0, &pgconn.PgError{Code: "23505", Message: "duplicate key value"}
+		}
+		db.rows[name], db.checksums[name] = args[1].Value.(int64), args[2].Value.([]byte)
+		affected = 1
+	case "select_signed":
+		name := args[0].Value.(string)
+		if current, ok := db.rows[name]; ok {
+			rows = [][]driver.Value{{current, db.checksums[name]}}
+		}
+	case "select":
+		if current, ok := db.rows[args[0].Value.(string)]; ok {
+			rows = [][]driver.Value{{current}}
+		}
+	default:
+		return nil, 0, errors.New("fakeDB: unsupported statement " + kind)
+	}
+	db.statements[kind]++
+	return rows, affected, nil
+}
+
+type fakeConnector struct{ db *fakeDB }
+
+func (c fakeConnector) Connect(context.Context) (driver.Conn, error) { return fakeConn(c), nil }
+func (c fakeConnector) Driver() driver.Driver                        { return fakeDriver{} }
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("fakeDB: open through fakeConnector")
+}
+
+type fakeConn struct{ db *fakeDB }
+
+func (c fakeConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("fakeDB: prepared statements are not supported")
+}
+func (c fakeConn) Close() error              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error) { return fakeTx{}, nil }
+
+// fakeTx applies statements as they run; fakeDB's lock stands in for the
+// row lock, and a rollback undoes nothing.
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+func (c fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	_, affected, err := c.db.run(statementKind(query), args)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(affected), nil
+}
+
+func (c fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	rows, _, err := c.db.run(statementKind(query), args)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{values: rows}, nil
+}
+
+type fakeRows struct {
+	values [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	if len(r.values) > 0 && len(r.values[0]) == 2 {
+		return []string{"count", "checksum"}
+	}
+	return []string{"count"}
+}
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
@@ -0,0 +1,105 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// RuntimeFlags are behaviors operators can toggle through /admin/flags
+// without a restart. They start from environment defaults and are never
+// persisted.
+type RuntimeFlags struct {
+	// MaintenanceMode rejects increments with 503.
+	MaintenanceMode atomic.Bool
+	// DBNodeLookup controls whether responses include db_node.
+	DBNodeLookup atomic.Bool
+	// VerboseLogging logs every increment.
+	VerboseLogging atomic.Bool
+}
+
+// flagsSnapshot is the JSON form of RuntimeFlags. Pointers let PUT bodies
+// change a subset of flags.
+type flagsSnapshot struct {
+	MaintenanceMode *bool `json:"maintenance_mode,omitempty"`
+	DBNodeLookup    *bool `json:"db_node_lookup,omitempty"`
+	VerboseLogging  *bool `json:"verbose_logging,omitempty"`
+}
+
+func getEnvBool(key string, fallback bool) bool {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return fallback
+	}
+
+	value, err := strconv.ParseBool(raw)
+	if err != nil {
+		slog.Warn("Invalid "+key+". Using default.", "value", raw, "default", fallback)
+		return fallback
+	}
+
+	return value
+}
+
+// NewRuntimeFlags reads the initial flag values from MAINTENANCE_MODE,
+// DB_NODE_LOOKUP and VERBOSE_LOGGING.
+func NewRuntimeFlags() *RuntimeFlags {
+	flags := &RuntimeFlags{}
+	flags.MaintenanceMode.Store(getEnvBool("MAINTENANCE_MODE", false))
+	flags.DBNodeLookup.Store(getEnvBool("DB_NODE_LOOKUP", true))
+	flags.VerboseLogging.Store(getEnvBool("VERBOSE_LOGGING", false))
+	return flags
+}
+
+func (f *RuntimeFlags) snapshot() flagsSnapshot {
+	maintenance := f.MaintenanceMode.Load()
+	dbNodeLookup := f.DBNodeLookup.Load()
+	verbose := f.VerboseLogging.Load()
+	return flagsSnapshot{
+		MaintenanceMode: &maintenance,
+		DBNodeLookup:    &dbNodeLookup,
+		VerboseLogging:  &verbose,
+	}
+}
+
+// FlagsHandler reports the runtime flags on GET and updates the flags present
+// in the JSON body on PUT.
+func FlagsHandler(flags *RuntimeFlags) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			var update flagsSnapshot
+			decoder := json.NewDecoder(r.Body)
+			decoder.DisallowUnknownFields()
+			if err := decoder.Decode(&update); err != nil {
+				writeJSON(w, http.StatusBadRequest, errorResponse{Message: "invalid flags body: " + err.Error()})
+				return
+			}
+
+			if update.MaintenanceMode != nil {
+				flags.MaintenanceMode.Store(*update.MaintenanceMode)
+			}
+			if update.DBNodeLookup != nil {
+				flags.DBNodeLookup.Store(*update.DBNodeLookup)
+			}
+			if update.VerboseLogging != nil {
+				flags.VerboseLogging.Store(*update.VerboseLogging)
+			}
+			slog.InfoContext(r.Context(), "Runtime flags updated", "principal", tokenPrincipal(r), "flags", flagsString(flags.snapshot()))
+		}
+
+		writeJSON(w, http.StatusOK, flags.snapshot())
+	}
+}
+
+func flagsString(snapshot flagsSnapshot) string {
+	return "maintenance_mode=" + strconv.FormatBool(*snapshot.MaintenanceMode) +
+		" db_node_lookup=" + strconv.FormatBool(*snapshot.DBNodeLookup) +
+		" verbose_logging=" + strconv.FormatBool(*snapshot.VerboseLogging)
+}
@@ -0,0 +1,261 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Generation is a past era of the default counter: the total it had reached
+// when it was rotated out.
+type Generation struct {
+	ID        int64     `json:"id"`
+	Count     int64     `json:"count"`
+	RotatedAt time.Time `json:"rotated_at"`
+}
+
+// Rotator is implemented by stores that can archive the default counter as a
+// numbered generation and start it again from zero.
+type Rotator interface {
+	Rotate(ctx context.Context) (Generation, error)
+	Generations(ctx context.Context) ([]Generation, error)
+}
+
+func (m *InMemoryStore) Rotate(ctx context.Context) (Generation, error) {
+	_ = ctx
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	gen := Generation{
+		ID:        int64(len(m.generations)) + 1,
+		Count:     m.counts[defaultCounterName],
+		RotatedAt: time.Now().UTC(),
+	}
+	m.generations = append(m.generations, gen)
+	if m.counts != nil {
+		m.counts[defaultCounterName] = 0
+	}
+	return gen, nil
+}
+
+func (m *InMemoryStore) Generations(ctx context.Context) ([]Generation, error) {
+	_ = ctx
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Generation{}, m.generations...), nil
+}
+
+// ensureGenerationsTable creates the companion table once per process.
+func (c *CockroachStore) ensureGenerationsTable(ctx context.Context, conn *sql.Conn) error {
+	if c.generationsTableReady.Load() {
+		return nil
+	}
+	if _, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS counter_generations (
+		id INT8 PRIMARY KEY,
+		count INT8 NOT NULL,
+		rotated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`); err != nil {
+		return err
+	}
+	c.generationsTableReady.Store(true)
+	return nil
+}
+
+// Rotate archives and zeroes the default counter in one transaction. The
+// counter row is locked first, so increments wait rather than land in the
+// archived total after it was read.
+func (c *CockroachStore) Rotate(ctx context.Context) (Generation, error) {
+	conn, err := c.conn(ctx)
+	if err != nil {
+		return Generation{}, err
+	}
+	defer conn.Close()
+
+	if err := c.ensureSchema(ctx, conn); err != nil {
+		observeQueryErr(ctx, err)
+		return Generation{}, err
+	}
+	if err := c.ensureGenerationsTable(ctx, conn); err != nil {
+		observeQueryErr(ctx, err)
+		return Generation{}, err
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return Generation{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var count int64
+	err = tx.QueryRowContext(ctx, `SELECT count FROM counters WHERE name = $1 FOR UPDATE`, defaultCounterName).Scan(&count)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Generation{}, errCounterRowMissing
+	}
+	if err != nil {
+		observeQueryErr(ctx, err)
+		return Generation{}, err
+	}
+
+	var gen Generation
+	err = tx.QueryRowContext(ctx, `INSERT INTO counter_generations (id, count)
+		SELECT COALESCE(max(id), 0) + 1, $1 FROM counter_generations
+		RETURNING id, count, rotated_at`, count).Scan(&gen.ID, &gen.Count, &gen.RotatedAt)
+	if err != nil {
+		observeQueryErr(ctx, err)
+		return Generation{}, err
+	}
+
+	if c.hmacKey != nil {
+		_, err = tx.ExecContext(ctx, `UPDATE counters SET count = 0, checksum = $2 WHERE name = $1`, defaultCounterName, countChecksum(c.hmacKey, defaultCounterName, 0))
+	} else {
+		_, err = tx.ExecContext(ctx, `UPDATE counters SET count = 0 WHERE name = $1`, defaultCounterName)
+	}
+	if err != nil {
+		observeQueryErr(ctx, err)
+		return Generation{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		observeQueryErr(ctx, err)
+		return Generation{}, err
+	}
+	return gen, nil
+}
+
+// Generations lists archived generations, oldest first. Before the first
+// rotate the table may not exist yet, which reads as no generations.
+func (c *CockroachStore) Generations(ctx context.Context) ([]Generation, error) {
+	conn, err := c.readConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	rows, err := conn.QueryContext(ctx, `SELECT id, count, rotated_at FROM counter_generations ORDER BY id`)
+	if isUndefinedTable(err) {
+		return []Generation{}, nil
+	}
+	if err != nil {
+		observeQueryErr(ctx, err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanGenerations(rows)
+}
+
+func (s *SQLiteStore) Rotate(ctx context.Context) (Generation, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		observeQueryErr(ctx, err)
+		return Generation{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var count int64
+	if err := tx.QueryRowContext(ctx, `SELECT count FROM counters WHERE name = ?`, defaultCounterName).Scan(&count); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		observeQueryErr(ctx, err)
+		return Generation{}, err
+	}
+
+	gen := Generation{Count: count, RotatedAt: time.Now().UTC()}
+	err = tx.QueryRowContext(ctx, `INSERT INTO counter_generations (id, count, rotated_at)
+		SELECT COALESCE(max(id), 0) + 1, ?, ? FROM counter_generations
+		RETURNING id`, gen.Count, gen.RotatedAt).Scan(&gen.ID)
+	if err != nil {
+		observeQueryErr(ctx, err)
+		return Generation{}, err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE counters SET count = 0 WHERE name = ?`, defaultCounterName); err != nil {
+		observeQueryErr(ctx, err)
+		return Generation{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		observeQueryErr(ctx, err)
+		return Generation{}, err
+	}
+	return gen, nil
+}
+
+func (s *SQLiteStore) Generations(ctx context.Context) ([]Generation, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, count, rotated_at FROM counter_generations ORDER BY id`)
+	if err != nil {
+		observeQueryErr(ctx, err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanGenerations(rows)
+}
+
+func scanGenerations(rows *sql.Rows) ([]Generation, error) {
+	generations := []Generation{}
+	for rows.Next() {
+		var gen Generation
+		if err := rows.Scan(&gen.ID, &gen.Count, &gen.RotatedAt); err != nil {
+			return nil, err
+		}
+		generations = append(generations, gen)
+	}
+	return generations, rows.Err()
+}
+
+// RotateHandler archives the default counter as a new generation, resets it
+// to zero and wakes long polls.
+func RotateHandler(store CounterStore, timeout time.Duration, watcher *CountWatcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rotator, ok := store.(Rotator)
+		if !ok {
+			writeJSON(w, http.StatusNotImplemented, errorResponse{Message: "the configured storage mode does not support generations"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		gen, err := rotator.Rotate(ctx)
+		if err != nil {
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(timeout)))
+			writeJSON(w, http.StatusServiceUnavailable, errorResponse{Message: "DB Error: " + err.Error()})
+			return
+		}
+		setAuditValues(r.Context(), gen.Count, 0)
+		currentCount.Set(0)
+		watcher.Notify(defaultCounterName)
+
+		writeJSON(w, http.StatusOK, gen)
+	}
+}
+
+type generationsResponse struct {
+	Generations []Generation `json:"generations"`
+}
+
+// GenerationsHandler lists past generations with their final totals.
+func GenerationsHandler(store CounterStore, timeout time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rotator, ok := store.(Rotator)
+		if !ok {
+			writeJSON(w, http.StatusNotImplemented, errorResponse{Message: "the configured storage mode does not support generations"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		generations, err := rotator.Generations(ctx)
+		if err != nil {
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(timeout)))
+			writeJSON(w, http.StatusServiceUnavailable, errorResponse{Message: "DB Error: " + err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, generationsResponse{Generations: generations})
+	}
+}
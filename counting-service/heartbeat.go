@@ -0,0 +1,43 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// runHeartbeat logs the current count every interval until ctx is cancelled,
+// for deployments with no metrics pipeline.
+func runHeartbeat(ctx context.Context, store CounterStore, interval, timeout time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			logHeartbeat(ctx, store, timeout)
+		}
+	}
+}
+
+func logHeartbeat(ctx context.Context, store CounterStore, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	count, err := store.GetCount(ctx, defaultCounterName)
+	if err != nil {
+		slog.Error("Heartbeat failed", "error", err)
+		return
+	}
+
+	args := []any{"count", count}
+	if node, err := store.GetDBNode(ctx); err == nil && node != "" {
+		args = append(args, "db_node", node)
+	}
+	slog.Info("Heartbeat", args...)
+}
@@ -0,0 +1,170 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultErrorLogInterval = 10 * time.Second
+
+// maxErrorLogKeys bounds how many distinct errors errorLogLimiter tracks.
+const maxErrorLogKeys = 1000
+
+// storeErrorLog limits "Store request failed" lines, from
+// ERROR_LOG_INTERVAL_MS. When nil every error is logged.
+var storeErrorLog *errorLogLimiter
+
+// setupLogging makes a JSON slog handler at the LOG_LEVEL level the default
+// logger. slog.SetDefault also routes the standard log package through it,
+// so output from dependencies that use it comes out as JSON too, at info
+// level.
+func setupLogging() {
+	level, valid := parseLogLevel(os.Getenv("LOG_LEVEL"))
+	handler := slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: formatLogAttr,
+	})
+	slog.SetDefault(slog.New(requestIDHandler{handler}))
+	if !valid {
+		slog.Warn("Invalid LOG_LEVEL. Using default.", "value", os.Getenv("LOG_LEVEL"), "default", slog.LevelInfo.String())
+	}
+}
+
+func parseLogLevel(raw string) (level slog.Level, valid bool) {
+	switch strings.ToLower(strings.TrimSpace(raw)) {
+	case "debug":
+		return slog.LevelDebug, true
+	case "", "info":
+		return slog.LevelInfo, true
+	case "warn", "warning":
+		return slog.LevelWarn, true
+	case "error":
+		return slog.LevelError, true
+	default:
+		return slog.LevelInfo, false
+	}
+}
+
+// formatLogAttr writes durations as "1.5s" rather than integer nanoseconds,
+// to match the env vars and startup output.
+func formatLogAttr(_ []string, a slog.Attr) slog.Attr {
+	if a.Value.Kind() == slog.KindDuration {
+		return slog.String(a.Key, a.Value.Duration().String())
+	}
+	return a
+}
+
+// requestIDHandler adds the request_id attached by withRequestID to records
+// logged with a request's context.
+type requestIDHandler struct {
+	slog.Handler
+}
+
+func (h requestIDHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := requestIDFrom(ctx); id != "" {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDHandler) WithGroup(name string) slog.Handler {
+	return requestIDHandler{h.Handler.WithGroup(name)}
+}
+
+// errorLogLimiter lets each distinct error be logged at most once per
+// interval, so an outage does not write one line per failed request. The
+// first occurrence is always logged; the ones suppressed after it are counted
+// and reported with the next line logged for that error.
+type errorLogLimiter struct {
+	interval time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*errorLogEntry
+}
+
+type errorLogEntry struct {
+	last       time.Time
+	suppressed int
+}
+
+func newErrorLogLimiter(interval time.Duration) *errorLogLimiter {
+	return &errorLogLimiter{interval: interval, entries: make(map[string]*errorLogEntry)}
+}
+
+// allow reports whether key may be logged at now and, if so, how many
+// occurrences were suppressed since it last was.
+func (l *errorLogLimiter) allow(key string, now time.Time) (ok bool, suppressed int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry, found := l.entries[key]
+	if !found {
+		if len(l.entries) >= maxErrorLogKeys {
+			l.evict(now)
+		}
+		if len(l.entries) < maxErrorLogKeys {
+			l.entries[key] = &errorLogEntry{last: now}
+		}
+		return true, 0
+	}
+	if now.Sub(entry.last) < l.interval {
+		entry.suppressed++
+		return false, 0
+	}
+	suppressed = entry.suppressed
+	entry.last, entry.suppressed = now, 0
+	return true, suppressed
+}
+
+// evict drops entries with nothing suppressed that are past their interval,
+// since forgetting them loses nothing.
+func (l *errorLogLimiter) evict(now time.Time) {
+	for key, entry := range l.entries {
+		if entry.suppressed == 0 && now.Sub(entry.last) >= l.interval {
+			delete(l.entries, key)
+		}
+	}
+}
+
+// logStoreError logs a failed store request at error level, subject to
+// storeErrorLog. Errors are told apart by message and error text.
+func logStoreError(ctx context.Context, msg string, err error, args ...any) {
+	args = append(args, "error", err)
+	if storeErrorLog != nil {
+		ok, suppressed := storeErrorLog.allow(msg+"\x00"+err.Error(), time.Now())
+		if !ok {
+			return
+		}
+		if suppressed > 0 {
+			args = append(args, "suppressed", suppressed)
+		}
+	}
+	slog.ErrorContext(ctx, msg, args...)
+}
+
+// fatalCloser, once the store is open, is closed by fatal before exiting so
+// a startup error still releases the store's connections.
+var fatalCloser io.Closer
+
+// fatal logs msg at error level and exits, like log.Fatal.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	if fatalCloser != nil {
+		if err := fatalCloser.Close(); err != nil {
+			slog.Error("Closing store failed", "error", err)
+		}
+	}
+	os.Exit(1)
+}
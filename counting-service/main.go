@@ -5,45 +5,123 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"database/sql"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"math"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
-	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 )
 
-const defaultDBRequestTimeout = 1 * time.Second
+// defaultDBRequestTimeouts holds the request timeout used for each storage
+// mode when DB_REQUEST_TIMEOUT_MS is not set.
+var defaultDBRequestTimeouts = map[string]time.Duration{
+	"memory":    100 * time.Millisecond,
+	"cockroach": 1 * time.Second,
+	"redis":     500 * time.Millisecond,
+	"sqlite":    1 * time.Second,
+}
+
+const defaultCountFormatSeparator = ","
+const defaultDBConnAcquireTimeout = 250 * time.Millisecond
+
+// The database/sql pool defaults: unlimited open connections, two idle.
+const defaultDBMaxOpenConns = 0
+const defaultDBMaxIdleConns = 2
+const defaultPort = "9001"
+const defaultStartupCheckTimeout = 10 * time.Second
+const defaultShutdownTimeout = 5 * time.Second
+const maxCheckoutValidationAttempts = 3
 const defaultDNSNetwork = "udp"
 const defaultDNSPort = "53"
 const defaultDNSTimeout = 1500 * time.Millisecond
+const defaultDNSMaxTimeout = 5 * time.Second
 
-// CounterStore describes storage operations for the counter.
+// CounterStore describes storage operations for the counters. Counters are
+// identified by name and created on their first increment; / serves
+// defaultCounterName.
 type CounterStore interface {
-	Incr(ctx context.Context) (int64, error)
+	Incr(ctx context.Context, name string) (int64, error)
+	Decr(ctx context.Context, name string) (int64, error)
+	// IncrBy adds delta, which may be negative, in one atomic step.
+	IncrBy(ctx context.Context, name string, delta int64) (int64, error)
+	GetCount(ctx context.Context, name string) (int64, error)
+	Reset(ctx context.Context, name string) error
 	GetDBNode(ctx context.Context) (string, error)
 }
 
-// InMemoryStore implements an in-memory counter.
+// InMemoryStore implements in-memory counters.
 type InMemoryStore struct {
-	mu    sync.Mutex
-	count int64
+	mu          sync.Mutex
+	counts      map[string]int64
+	generations []Generation
+}
+
+// NewInMemoryStore returns a store whose default counter starts at initial.
+func NewInMemoryStore(initial int64) *InMemoryStore {
+	return &InMemoryStore{counts: map[string]int64{defaultCounterName: initial}}
+}
+
+// add applies delta unless the result would overflow, which the DB-backed
+// stores also refuse.
+func (m *InMemoryStore) add(name string, delta int64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.counts == nil {
+		m.counts = make(map[string]int64)
+	}
+	current := m.counts[name]
+	if (delta > 0 && current > math.MaxInt64-delta) || (delta < 0 && current < math.MinInt64-delta) {
+		return current, errCountOverflow
+	}
+	m.counts[name] = current + delta
+	return m.counts[name], nil
+}
+
+func (m *InMemoryStore) Incr(ctx context.Context, name string) (int64, error) {
+	_ = ctx
+	return m.add(name, 1)
+}
+
+func (m *InMemoryStore) Decr(ctx context.Context, name string) (int64, error) {
+	_ = ctx
+	return m.add(name, -1)
+}
+
+func (m *InMemoryStore) IncrBy(ctx context.Context, name string, delta int64) (int64, error) {
+	_ = ctx
+	return m.add(name, delta)
 }
 
-func (m *InMemoryStore) Incr(ctx context.Context) (int64, error) {
+func (m *InMemoryStore) GetCount(ctx context.Context, name string) (int64, error) {
 	_ = ctx
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	m.count++
-	return m.count, nil
+	return m.counts[name], nil
 }
 
 func (m *InMemoryStore) GetDBNode(ctx context.Context) (string, error) {
@@ -51,104 +129,1552 @@ func (m *InMemoryStore) GetDBNode(ctx context.Context) (string, error) {
 	return "", nil
 }
 
+// errCounterRowMissing is returned when the default counter's seed row has
+// been removed out-of-band and DB_RECREATE_MISSING_ROW is disabled.
+var errCounterRowMissing = errors.New("counter row missing: counters row 'default' does not exist")
+
+// errCountOverflow is returned when an increment would take a counter past
+// the int64 range.
+var errCountOverflow = errors.New("count out of range")
+
+// errPoolExhausted is returned when every pooled connection stays busy for
+// longer than the connection acquisition timeout.
+var errPoolExhausted = errors.New("DB connection pool exhausted")
+
+// CockroachConfig holds the settings used to build a CockroachStore.
+type CockroachConfig struct {
+	PGURL string
+	// ReadPGURL, if set, opens a second pool for GetCount, GetDBNode and
+	// VerifySchema, typically as a read-only role. Writes always use PGURL.
+	ReadPGURL          string
+	ConnAcquireTimeout time.Duration
+	// ConnectTimeout bounds establishing each new DB connection. Zero keeps
+	// the connect_timeout from PGURL, if any.
+	ConnectTimeout time.Duration
+	// QueryComments prefixes the increment statement with a SQL comment
+	// identifying the request, visible in CockroachDB's statement stats.
+	QueryComments bool
+	// NodeCacheTTL caches the GetDBNode result for this long. Zero disables
+	// the cache.
+	NodeCacheTTL time.Duration
+	// Pool sizes and recycles the connections of both pools.
+	Pool PoolConfig
+	// RecreateMissingRow re-creates the default counter's row if it
+	// disappears. When false the row is only seeded until that first
+	// succeeds, and a later missing row is reported as errCounterRowMissing.
+	// Named counters are always created on demand.
+	RecreateMissingRow bool
+	// ValidateOnCheckout pings each connection after checkout and replaces
+	// it if the ping fails.
+	ValidateOnCheckout bool
+	// HMACKey, if set, stores an HMAC of the count in a checksum column and
+	// verifies it on every read and write, to detect out-of-band edits.
+	HMACKey []byte
+	// StatementMetrics records per-operation latency histograms.
+	StatementMetrics bool
+	// BootstrapRetries is how many times schema creation is retried after
+	// conflicting with another instance creating it at the same time.
+	BootstrapRetries int
+	// MaxRetries is how many times a write is retried after a failure that
+	// left the count untouched, waiting RetryBase, then twice that, and so on.
+	MaxRetries int
+	RetryBase  time.Duration
+}
+
 // CockroachStore uses CockroachDB for persistence.
 type CockroachStore struct {
-	db *sql.DB
+	db                 *sql.DB
+	readDB             *sql.DB // same as db unless ReadPGURL is set
+	connAcquireTimeout time.Duration
+	queryComments      bool
+	recreateMissingRow bool
+	validateOnCheckout bool
+	// schemaReady is set once ensureSchema has created the table and seeded
+	// the default row, and cleared if the table is later found missing.
+	schemaReady      atomic.Bool
+	bootstrapSem     chan struct{} // held by the caller running ensureSchema
+	bootstrapRetries int
+
+	maxRetries int
+	retryBase  time.Duration
+
+	hmacKey             []byte
+	checksumColumnReady atomic.Bool
+
+	generationsTableReady atomic.Bool
+
+	statementMetrics bool
+
+	nodeCacheTTL time.Duration
+	nodeMu       sync.Mutex
+	node         string
+	nodeCachedAt time.Time
+}
+
+// PoolConfig holds the database/sql pool limits. Zero means unlimited, as in
+// database/sql, except MaxIdleConns, where it keeps no idle connections.
+type PoolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
 }
 
-func NewCockroachStore(pgURL string) (*CockroachStore, error) {
-	db, err := sql.Open("pgx", pgURL)
+func NewCockroachStore(cfg CockroachConfig) (*CockroachStore, error) {
+	db, err := openPool(cfg.PGURL, cfg.ConnectTimeout, cfg.Pool)
 	if err != nil {
 		return nil, err
 	}
-	return &CockroachStore{db: db}, nil
+	readDB := db
+	if cfg.ReadPGURL != "" {
+		if readDB, err = openPool(cfg.ReadPGURL, cfg.ConnectTimeout, cfg.Pool); err != nil {
+			return nil, fmt.Errorf("read pool: %w", err)
+		}
+	}
+
+	return &CockroachStore{
+		db:                 db,
+		readDB:             readDB,
+		connAcquireTimeout: cfg.ConnAcquireTimeout,
+		queryComments:      cfg.QueryComments,
+		recreateMissingRow: cfg.RecreateMissingRow,
+		validateOnCheckout: cfg.ValidateOnCheckout,
+		hmacKey:            cfg.HMACKey,
+		statementMetrics:   cfg.StatementMetrics,
+		nodeCacheTTL:       cfg.NodeCacheTTL,
+		bootstrapSem:       make(chan struct{}, 1),
+		bootstrapRetries:   cfg.BootstrapRetries,
+		maxRetries:         cfg.MaxRetries,
+		retryBase:          cfg.RetryBase,
+	}, nil
+}
+
+// Close closes the write pool and, if separate, the read pool.
+func (c *CockroachStore) Close() error {
+	err := c.db.Close()
+	if c.readDB != c.db {
+		err = errors.Join(err, c.readDB.Close())
+	}
+	return err
+}
+
+// openPool opens a connection pool for pgURL. Connections are opened lazily
+// under the caller's context, so a dial never outlives the request that
+// triggered it.
+func openPool(pgURL string, connectTimeout time.Duration, pool PoolConfig) (*sql.DB, error) {
+	connConfig, err := pgx.ParseConfig(pgURL)
+	if err != nil {
+		return nil, err
+	}
+	if connectTimeout > 0 {
+		connConfig.ConnectTimeout = connectTimeout
+	}
+	db := stdlib.OpenDB(*connConfig)
+	db.SetMaxOpenConns(pool.MaxOpenConns)
+	db.SetMaxIdleConns(pool.MaxIdleConns)
+	db.SetConnMaxLifetime(pool.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(pool.ConnMaxIdleTime)
+	return db, nil
+}
+
+type queryTagsKey struct{}
+
+type queryTags struct {
+	requestID string
+	source    string
+}
+
+// withQueryTags attaches request metadata that CockroachStore can embed in
+// SQL comments when DB_QUERY_COMMENTS is enabled.
+func withQueryTags(ctx context.Context, requestID, source string) context.Context {
+	return context.WithValue(ctx, queryTagsKey{}, queryTags{requestID: requestID, source: source})
+}
+
+// sanitizeQueryTag keeps only characters that cannot terminate or escape a
+// SQL comment.
+func sanitizeQueryTag(value string) string {
+	const maxLen = 64
+	var b strings.Builder
+	for _, r := range value {
+		if b.Len() >= maxLen {
+			break
+		}
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9',
+			r == '-', r == '_', r == '.', r == ':':
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// withQueryComment prefixes query with a /* request_id=... source=... */
+// comment when comments are enabled and the context carries a request ID.
+func (c *CockroachStore) withQueryComment(ctx context.Context, query string) string {
+	if !c.queryComments {
+		return query
+	}
+	tags, ok := ctx.Value(queryTagsKey{}).(queryTags)
+	if !ok {
+		return query
+	}
+	requestID := sanitizeQueryTag(tags.requestID)
+	if requestID == "" {
+		return query
+	}
+	return fmt.Sprintf("/* request_id=%s source=%s */ %s", requestID, sanitizeQueryTag(tags.source), query)
+}
+
+// conn checks out a connection from the write pool.
+func (c *CockroachStore) conn(ctx context.Context) (*sql.Conn, error) {
+	return c.checkout(ctx, c.db)
+}
+
+// readConn checks out a connection from the read pool.
+func (c *CockroachStore) readConn(ctx context.Context) (*sql.Conn, error) {
+	return c.checkout(ctx, c.readDB)
+}
+
+// checkout takes a connection from db, validating it first when
+// validateOnCheckout is set. A connection that fails its ping is discarded
+// and another is checked out, so a DB node restart costs a retry instead of a
+// failed request.
+func (c *CockroachStore) checkout(ctx context.Context, db *sql.DB) (*sql.Conn, error) {
+	if !c.validateOnCheckout {
+		return c.acquire(ctx, db)
+	}
+
+	var pingErr error
+	for attempt := 0; attempt < maxCheckoutValidationAttempts; attempt++ {
+		conn, err := c.acquire(ctx, db)
+		if err != nil {
+			return nil, err
+		}
+		// pgx reports a failed ping as driver.ErrBadConn, so database/sql
+		// drops the connection instead of returning it to the pool.
+		if pingErr = conn.PingContext(ctx); pingErr == nil {
+			return conn, nil
+		}
+		_ = conn.Close()
+		dbCheckoutValidationFailuresTotal.Inc()
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, fmt.Errorf("no healthy DB connection after validating checkout: %w", pingErr)
+}
+
+// acquire checks out a pooled connection. When DB_MAX_OPEN_CONNS caps the
+// pool and every connection is in use, waiting for one is bounded by
+// connAcquireTimeout, so a saturated pool fails fast instead of consuming the
+// whole request budget before the statement even starts. Otherwise a
+// checkout never waits on other requests, only on dialing a new connection,
+// which DB_CONNECT_TIMEOUT_MS and ctx bound instead.
+func (c *CockroachStore) acquire(ctx context.Context, db *sql.DB) (*sql.Conn, error) {
+	if stats := db.Stats(); stats.MaxOpenConnections == 0 || stats.InUse < stats.MaxOpenConnections {
+		return db.Conn(ctx)
+	}
+
+	acquireCtx, cancel := context.WithTimeout(ctx, c.connAcquireTimeout)
+	defer cancel()
+
+	conn, err := db.Conn(acquireCtx)
+	if err == nil {
+		return conn, nil
+	}
+	if ctx.Err() != nil || !errors.Is(err, context.DeadlineExceeded) {
+		return nil, err
+	}
+
+	stats := db.Stats()
+	dbConnAcquireTimeoutsTotal.Inc("pool_exhausted")
+	return nil, fmt.Errorf("%w (%d/%d connections in use)", errPoolExhausted, stats.InUse, stats.MaxOpenConnections)
+}
+
+// timeStatement starts timing a DB operation. Call the returned func when it
+// finishes, typically with defer c.timeStatement("op")().
+func (c *CockroachStore) timeStatement(operation string) func() {
+	if !c.statementMetrics {
+		return func() {}
+	}
+	start := time.Now()
+	return func() { dbStatementDuration.ObserveSince(start, operation) }
 }
 
-func (c *CockroachStore) ensureSchema(ctx context.Context) error {
-	_, err := c.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS counts (
-		id INT PRIMARY KEY,
-		count BIGINT NOT NULL
-	)`)
+// observeQueryErr records statements that failed because the request ran out
+// of time, as opposed to connection checkout timeouts.
+func observeQueryErr(ctx context.Context, err error) {
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		dbQueryTimeoutsTotal.Inc()
+	}
+}
+
+// noteQueryErr clears schemaReady when err shows the counters table is gone,
+// for example after the database was recreated, so the next write creates it
+// again.
+func (c *CockroachStore) noteQueryErr(err error) {
+	if isUndefinedTable(err) {
+		c.schemaReady.Store(false)
+		c.checksumColumnReady.Store(false)
+	}
+}
+
+// migrateLegacyRow copies the single counter from the pre-named-counters
+// counts table (row id=1) into the default counter, if the default counter
+// does not exist yet. The counts table is left in place.
+func (c *CockroachStore) migrateLegacyRow(ctx context.Context, conn *sql.Conn) error {
+	var legacy int64
+	err := conn.QueryRowContext(ctx, `SELECT count FROM counts WHERE id = 1`).Scan(&legacy)
+	if errors.Is(err, sql.ErrNoRows) || isUndefinedTable(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	result, err := c.insertRow(ctx, conn, defaultCounterName, legacy)
 	if err != nil {
 		return err
 	}
+	if migrated, _ := result.RowsAffected(); migrated == 1 {
+		slog.Info("Migrated count from the legacy counts table", "count", legacy, "counter", defaultCounterName)
+	}
+	return nil
+}
+
+// isUndefinedTable reports whether err is Postgres error 42P01.
+func isUndefinedTable(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "42P01"
+}
+
+func (c *CockroachStore) seedRow(ctx context.Context, conn *sql.Conn) error {
+	defer c.timeStatement("seed_row")()
 
-	_, err = c.db.ExecContext(ctx, `INSERT INTO counts (id, count) VALUES (1, 0)
-		ON CONFLICT (id) DO NOTHING`)
+	_, err := c.insertRow(ctx, conn, defaultCounterName, 0)
 	return err
 }
 
-func (c *CockroachStore) Incr(ctx context.Context) (int64, error) {
-	if err := c.ensureSchema(ctx); err != nil {
+// insertRow creates counter name at count unless it already exists.
+func (c *CockroachStore) insertRow(ctx context.Context, conn *sql.Conn, name string, count int64) (sql.Result, error) {
+	if c.hmacKey != nil {
+		return conn.ExecContext(ctx, `INSERT INTO counters (name, count, checksum) VALUES ($1, $2, $3)
+			ON CONFLICT (name) DO NOTHING`, name, count, countChecksum(c.hmacKey, name, count))
+	}
+	return conn.ExecContext(ctx, `INSERT INTO counters (name, count) VALUES ($1, $2)
+		ON CONFLICT (name) DO NOTHING`, name, count)
+}
+
+func (c *CockroachStore) Incr(ctx context.Context, name string) (int64, error) {
+	return c.add(ctx, name, 1)
+}
+
+func (c *CockroachStore) Decr(ctx context.Context, name string) (int64, error) {
+	return c.add(ctx, name, -1)
+}
+
+func (c *CockroachStore) IncrBy(ctx context.Context, name string, delta int64) (int64, error) {
+	return c.add(ctx, name, delta)
+}
+
+// add applies delta to counter name, creating the row if it does not exist.
+// The default counter is only re-created when recreateMissingRow is set.
+// Failures that left the count untouched, such as a node restarting, are
+// retried on a new connection.
+func (c *CockroachStore) add(ctx context.Context, name string, delta int64) (int64, error) {
+	return c.withWriteRetries(ctx, func() (int64, error) {
+		return c.addOnce(ctx, name, delta)
+	})
+}
+
+func (c *CockroachStore) addOnce(ctx context.Context, name string, delta int64) (int64, error) {
+	conn, err := c.conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	if err := c.ensureSchema(ctx, conn); err != nil {
+		observeQueryErr(ctx, err)
+		return 0, err
+	}
+
+	create := name != defaultCounterName || c.recreateMissingRow
+	count, err := c.addRow(ctx, conn, name, delta, create)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, errCounterRowMissing
+	}
+	if err != nil {
+		observeQueryErr(ctx, err)
+		c.noteQueryErr(err)
+		c.invalidateNodeCache()
+		return 0, err
+	}
+	return count, nil
+}
+
+// addRow applies delta in a single statement. With create it is an upsert;
+// otherwise a missing row yields sql.ErrNoRows.
+func (c *CockroachStore) addRow(ctx context.Context, conn *sql.Conn, name string, delta int64, create bool) (int64, error) {
+	operation := "incr"
+	if delta < 0 {
+		operation = "decr"
+	}
+	defer c.timeStatement(operation)()
+
+	if c.hmacKey != nil {
+		return c.addSignedRow(ctx, conn, name, delta, create)
+	}
+
+	query := `UPDATE counters SET count = count + $2 WHERE name = $1 RETURNING count`
+	if create {
+		query = `INSERT INTO counters (name, count) VALUES ($1, $2)
+			ON CONFLICT (name) DO UPDATE SET count = counters.count + excluded.count
+			RETURNING count`
+	}
+	var count int64
+	err := conn.QueryRowContext(ctx, c.withQueryComment(ctx, query), name, delta).Scan(&count)
+	return count, err
+}
+
+// GetCount reads counter name without incrementing it. A named counter that
+// has never been incremented reads as 0; the default counter is seeded, so
+// its absence is reported as errCounterRowMissing.
+func (c *CockroachStore) GetCount(ctx context.Context, name string) (int64, error) {
+	conn, err := c.readConn(ctx)
+	if err != nil {
 		return 0, err
 	}
+	defer conn.Close()
 
+	done := c.timeStatement("get_count")
 	var count int64
-	err := c.db.QueryRowContext(ctx, `UPDATE counts SET count = count + 1 WHERE id = 1 RETURNING count`).Scan(&count)
+	if c.hmacKey != nil {
+		var checksum []byte
+		err = conn.QueryRowContext(ctx, `SELECT count, checksum FROM counters WHERE name = $1`, name).Scan(&count, &checksum)
+		if err == nil {
+			c.verifyChecksum(name, count, checksum)
+		}
+	} else {
+		err = conn.QueryRowContext(ctx, `SELECT count FROM counters WHERE name = $1`, name).Scan(&count)
+	}
+	done()
+	if errors.Is(err, sql.ErrNoRows) {
+		if name != defaultCounterName {
+			return 0, nil
+		}
+		return 0, errCounterRowMissing
+	}
 	if err != nil {
+		observeQueryErr(ctx, err)
+		c.noteQueryErr(err)
 		return 0, err
 	}
 	return count, nil
 }
 
+// PoolUtilization reports the write pool's connections in use and its
+// DB_MAX_OPEN_CONNS limit, which is 0 when unlimited.
+func (c *CockroachStore) PoolUtilization() (inUse, maxOpen int) {
+	stats := c.db.Stats()
+	return stats.InUse, stats.MaxOpenConnections
+}
+
+// Ping checks that the write pool, and the read pool if separate, can reach
+// the database.
+func (c *CockroachStore) Ping(ctx context.Context) error {
+	if err := c.db.PingContext(ctx); err != nil {
+		return err
+	}
+	if c.readDB != c.db {
+		if err := c.readDB.PingContext(ctx); err != nil {
+			return fmt.Errorf("read pool: %w", err)
+		}
+	}
+	return nil
+}
+
+// StartupCheck runs query once on the write pool, after creating the schema,
+// so permission and schema problems stop startup instead of failing the
+// first request. The query runs in a transaction that is always rolled back,
+// so a write such as an UPDATE can be checked without changing the count.
+func (c *CockroachStore) StartupCheck(ctx context.Context, query string) error {
+	conn, err := c.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := c.ensureSchema(ctx, conn); err != nil {
+		return fmt.Errorf("creating schema: %w", err)
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	_, err = tx.ExecContext(ctx, query)
+	return err
+}
+
+// startupCheckHint explains the Postgres errors a misconfigured deployment
+// most often hits, or returns "" for anything else.
+func startupCheckHint(err error) string {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return ""
+	}
+	switch pgErr.Code {
+	case "42501":
+		return "the DB user lacks a privilege the query needs; check its GRANTs"
+	case "42P01":
+		return "the query references a table that does not exist"
+	case "42703":
+		return "the query references a column that does not exist"
+	}
+	return ""
+}
+
+// expectedCountersColumns lists the columns ensureSchema creates, using the
+// type names reported by information_schema.
+var expectedCountersColumns = []struct {
+	name     string
+	dataType string
+}{
+	{name: "name", dataType: "text"},
+	{name: "count", dataType: "bigint"},
+}
+
+// VerifySchema checks the counters table against expectedCountersColumns and
+// confirms the default counter's seed row is present.
+func (c *CockroachStore) VerifySchema(ctx context.Context) (SchemaReport, error) {
+	report := SchemaReport{Table: "counters", Discrepancies: []SchemaDiscrepancy{}}
+
+	conn, err := c.readConn(ctx)
+	if err != nil {
+		return report, err
+	}
+	defer conn.Close()
+	defer c.timeStatement("verify_schema")()
+
+	rows, err := conn.QueryContext(ctx, `SELECT column_name, data_type, is_nullable
+		FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = 'counters'`)
+	if err != nil {
+		observeQueryErr(ctx, err)
+		return report, err
+	}
+	defer rows.Close()
+
+	type column struct {
+		dataType string
+		nullable bool
+	}
+	actual := map[string]column{}
+	for rows.Next() {
+		var name, dataType, isNullable string
+		if err := rows.Scan(&name, &dataType, &isNullable); err != nil {
+			return report, err
+		}
+		actual[name] = column{dataType: dataType, nullable: isNullable == "YES"}
+	}
+	if err := rows.Err(); err != nil {
+		observeQueryErr(ctx, err)
+		return report, err
+	}
+
+	report.TableExists = len(actual) > 0
+	if !report.TableExists {
+		report.Discrepancies = append(report.Discrepancies, SchemaDiscrepancy{Problem: "table does not exist"})
+		return report, nil
+	}
+
+	for _, want := range expectedCountersColumns {
+		got, ok := actual[want.name]
+		delete(actual, want.name)
+		switch {
+		case !ok:
+			report.Discrepancies = append(report.Discrepancies, SchemaDiscrepancy{
+				Column: want.name, Problem: "missing column", Expected: want.dataType,
+			})
+		case got.dataType != want.dataType:
+			report.Discrepancies = append(report.Discrepancies, SchemaDiscrepancy{
+				Column: want.name, Problem: "unexpected type", Expected: want.dataType, Actual: got.dataType,
+			})
+		case got.nullable:
+			report.Discrepancies = append(report.Discrepancies, SchemaDiscrepancy{
+				Column: want.name, Problem: "column is nullable", Expected: "NOT NULL", Actual: "NULL",
+			})
+		}
+	}
+	for name, got := range actual {
+		if name == "checksum" {
+			// Optional; added when COUNT_HMAC_KEY is set.
+			continue
+		}
+		report.Discrepancies = append(report.Discrepancies, SchemaDiscrepancy{
+			Column: name, Problem: "unexpected column", Actual: got.dataType,
+		})
+	}
+
+	var primaryKey sql.NullString
+	err = conn.QueryRowContext(ctx, `SELECT string_agg(kcu.column_name, ',' ORDER BY kcu.ordinal_position)
+		FROM information_schema.table_constraints tc
+		JOIN information_schema.key_column_usage kcu
+			ON tc.constraint_name = kcu.constraint_name
+			AND tc.table_schema = kcu.table_schema
+			AND tc.table_name = kcu.table_name
+		WHERE tc.table_schema = current_schema() AND tc.table_name = 'counters'
+			AND tc.constraint_type = 'PRIMARY KEY'`).Scan(&primaryKey)
+	if err != nil {
+		observeQueryErr(ctx, err)
+		return report, err
+	}
+	if primaryKey.String != "name" {
+		report.Discrepancies = append(report.Discrepancies, SchemaDiscrepancy{
+			Problem: "unexpected primary key", Expected: "name", Actual: primaryKey.String,
+		})
+	}
+
+	var seeded bool
+	err = conn.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM counters WHERE name = $1)`, defaultCounterName).Scan(&seeded)
+	if err != nil {
+		observeQueryErr(ctx, err)
+		return report, err
+	}
+	if !seeded {
+		report.Discrepancies = append(report.Discrepancies, SchemaDiscrepancy{Problem: "seed row for the default counter is missing"})
+	}
+
+	report.OK = len(report.Discrepancies) == 0
+	return report, nil
+}
+
 func (c *CockroachStore) GetDBNode(ctx context.Context) (string, error) {
+	if node, ok := c.cachedNode(); ok {
+		return node, nil
+	}
+
+	conn, err := c.readConn(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
 	var nodeID int64
-	err := c.db.QueryRowContext(ctx, `SELECT crdb_internal.node_id()`).Scan(&nodeID)
+	done := c.timeStatement("get_db_node")
+	err = conn.QueryRowContext(ctx, `SELECT crdb_internal.node_id()`).Scan(&nodeID)
+	done()
+	if err != nil {
+		observeQueryErr(ctx, err)
+		c.invalidateNodeCache()
+		return "", err
+	}
+
+	node := fmt.Sprintf("Node %d", nodeID)
+	c.cacheNode(node)
+	return node, nil
+}
+
+func (c *CockroachStore) cachedNode() (string, bool) {
+	if c.nodeCacheTTL <= 0 {
+		return "", false
+	}
+	c.nodeMu.Lock()
+	defer c.nodeMu.Unlock()
+	if c.node == "" || time.Since(c.nodeCachedAt) > c.nodeCacheTTL {
+		return "", false
+	}
+	return c.node, true
+}
+
+func (c *CockroachStore) cacheNode(node string) {
+	if c.nodeCacheTTL <= 0 {
+		return
+	}
+	c.nodeMu.Lock()
+	defer c.nodeMu.Unlock()
+	c.node = node
+	c.nodeCachedAt = time.Now()
+}
+
+// invalidateNodeCache drops the cached node after a DB error, since
+// database/sql may have replaced the failed connection with one to a
+// different node.
+func (c *CockroachStore) invalidateNodeCache() {
+	c.nodeMu.Lock()
+	defer c.nodeMu.Unlock()
+	c.node = ""
+}
+
+func writeJSON(w http.ResponseWriter, status int, payload any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	noteWriteErr(w, "json", json.NewEncoder(w).Encode(payload))
+}
+
+// noteWriteErr counts and logs a response that failed to encode or write.
+// The status line is already sent by then, so there is nothing left to tell
+// the client; this only makes the failure visible. The request ID is read
+// back from the response header, where the handlers that have one set it.
+func noteWriteErr(w http.ResponseWriter, format string, err error) {
+	if err == nil {
+		return
+	}
+	responseWriteErrorsTotal.Inc(format)
+	args := []any{"format", format, "error", err}
+	if id := w.Header().Get("X-Request-ID"); id != "" {
+		args = append(args, "request_id", id)
+	}
+	slog.Warn("Failed to write response", args...)
+}
+
+// retryAfterSeconds converts a back-off duration into a Retry-After value,
+// rounding up so clients never retry earlier than intended.
+func retryAfterSeconds(d time.Duration) int {
+	seconds := int(math.Ceil(d.Seconds()))
+	if seconds < 1 {
+		return 1
+	}
+	return seconds
+}
+
+func getCustomDNSServer() string {
+	dnsServer := strings.TrimSpace(os.Getenv("DNS_SERVER"))
+	if dnsServer != "" {
+		return dnsServer
+	}
+	return strings.TrimSpace(os.Getenv("CONSUL_DNS_ADDR"))
+}
+
+func getCustomDNSNetwork() string {
+	dnsNetwork := strings.TrimSpace(os.Getenv("DNS_NETWORK"))
+	if dnsNetwork == "" {
+		return defaultDNSNetwork
+	}
+	return dnsNetwork
+}
+
+func getCustomDNSTimeout() time.Duration {
+	timeout := defaultDNSTimeout
+
+	raw := strings.TrimSpace(os.Getenv("DNS_TIMEOUT_MS"))
+	if raw != "" {
+		ms, err := strconv.Atoi(raw)
+		if err != nil || ms <= 0 {
+			slog.Warn("Invalid DNS_TIMEOUT_MS. Using default.", "value", raw, "default", defaultDNSTimeout)
+		} else {
+			timeout = time.Duration(ms) * time.Millisecond
+		}
+	}
+
+	maxTimeout := getCustomDNSMaxTimeout()
+	if timeout > maxTimeout {
+		slog.Warn("DNS timeout exceeds DNS_MAX_TIMEOUT_MS. Clamping.", "timeout", timeout, "max", maxTimeout)
+		return maxTimeout
+	}
+
+	return timeout
+}
+
+// getDNSCacheTTL returns DNS_CACHE_TTL_MS, how long a resolved DNS_SERVER
+// host is reused. 0 disables the cache.
+func getDNSCacheTTL() time.Duration {
+	raw := strings.TrimSpace(os.Getenv("DNS_CACHE_TTL_MS"))
+	if raw == "" {
+		return defaultDNSCacheTTL
+	}
+
+	ms, err := strconv.Atoi(raw)
+	if err != nil || ms < 0 {
+		slog.Warn("Invalid DNS_CACHE_TTL_MS. Using default.", "value", raw, "default", defaultDNSCacheTTL)
+		return defaultDNSCacheTTL
+	}
+
+	return time.Duration(ms) * time.Millisecond
+}
+
+func getCustomDNSMaxTimeout() time.Duration {
+	raw := strings.TrimSpace(os.Getenv("DNS_MAX_TIMEOUT_MS"))
+	if raw == "" {
+		return defaultDNSMaxTimeout
+	}
+
+	ms, err := strconv.Atoi(raw)
+	if err != nil || ms <= 0 {
+		slog.Warn("Invalid DNS_MAX_TIMEOUT_MS. Using default.", "value", raw, "default", defaultDNSMaxTimeout)
+		return defaultDNSMaxTimeout
+	}
+
+	return time.Duration(ms) * time.Millisecond
+}
+
+func getDBRequestTimeout(storageMode string) time.Duration {
+	defaultTimeout := defaultDBRequestTimeouts[storageMode]
+
+	raw := strings.TrimSpace(os.Getenv("DB_REQUEST_TIMEOUT_MS"))
+	if raw == "" {
+		return defaultTimeout
+	}
+
+	ms, err := strconv.Atoi(raw)
+	if err != nil || ms <= 0 {
+		slog.Warn("Invalid DB_REQUEST_TIMEOUT_MS. Using default.", "value", raw, "default", defaultTimeout, "storage_mode", storageMode)
+		return defaultTimeout
+	}
+
+	return time.Duration(ms) * time.Millisecond
+}
+
+func getDBConnAcquireTimeout() time.Duration {
+	raw := strings.TrimSpace(os.Getenv("DB_CONN_ACQUIRE_TIMEOUT_MS"))
+	if raw == "" {
+		return defaultDBConnAcquireTimeout
+	}
+
+	ms, err := strconv.Atoi(raw)
+	if err != nil || ms <= 0 {
+		slog.Warn("Invalid DB_CONN_ACQUIRE_TIMEOUT_MS. Using default.", "value", raw, "default", defaultDBConnAcquireTimeout)
+		return defaultDBConnAcquireTimeout
+	}
+
+	return time.Duration(ms) * time.Millisecond
+}
+
+func getClientTrackerSize() int {
+	raw := strings.TrimSpace(os.Getenv("CLIENT_TRACKER_SIZE"))
+	if raw == "" {
+		return defaultClientTrackerSize
+	}
+
+	size, err := strconv.Atoi(raw)
+	if err != nil || size <= 0 {
+		slog.Warn("Invalid CLIENT_TRACKER_SIZE. Using default.", "value", raw, "default", defaultClientTrackerSize)
+		return defaultClientTrackerSize
+	}
+
+	return size
+}
+
+func getClientTrackerWindow() time.Duration {
+	raw := strings.TrimSpace(os.Getenv("CLIENT_TRACKER_WINDOW_MS"))
+	if raw == "" {
+		return defaultClientTrackerWindow
+	}
+
+	ms, err := strconv.Atoi(raw)
+	if err != nil || ms <= 0 {
+		slog.Warn("Invalid CLIENT_TRACKER_WINDOW_MS. Using default.", "value", raw, "default", defaultClientTrackerWindow)
+		return defaultClientTrackerWindow
+	}
+
+	return time.Duration(ms) * time.Millisecond
+}
+
+func getDBConnectTimeout() time.Duration {
+	raw := strings.TrimSpace(os.Getenv("DB_CONNECT_TIMEOUT_MS"))
+	if raw == "" {
+		return 0
+	}
+
+	ms, err := strconv.Atoi(raw)
+	if err != nil || ms <= 0 {
+		slog.Warn("Invalid DB_CONNECT_TIMEOUT_MS. Using the connect_timeout from PG_URL.", "value", raw)
+		return 0
+	}
+
+	return time.Duration(ms) * time.Millisecond
+}
+
+// getDBPoolConfig reads the DB_MAX_OPEN_CONNS, DB_MAX_IDLE_CONNS,
+// DB_CONN_MAX_LIFETIME_MS and DB_CONN_MAX_IDLE_TIME_MS pool limits, keeping
+// the database/sql default for any that are unset or invalid.
+func getDBPoolConfig() PoolConfig {
+	return PoolConfig{
+		MaxOpenConns:    getPositiveEnvInt("DB_MAX_OPEN_CONNS", defaultDBMaxOpenConns),
+		MaxIdleConns:    getPositiveEnvInt("DB_MAX_IDLE_CONNS", defaultDBMaxIdleConns),
+		ConnMaxLifetime: time.Duration(getPositiveEnvInt("DB_CONN_MAX_LIFETIME_MS", 0)) * time.Millisecond,
+		ConnMaxIdleTime: time.Duration(getPositiveEnvInt("DB_CONN_MAX_IDLE_TIME_MS", 0)) * time.Millisecond,
+	}
+}
+
+func getPositiveEnvInt(key string, fallback int) int {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return fallback
+	}
+
+	n, err := strconv.Atoi(raw)
+	if err != nil || n <= 0 {
+		slog.Warn("Invalid "+key+". Using default.", "value", raw, "default", fallback)
+		return fallback
+	}
+
+	return n
+}
+
+func getDBNodeCacheTTL() time.Duration {
+	raw := strings.TrimSpace(os.Getenv("DB_NODE_CACHE_TTL_MS"))
+	if raw == "" {
+		return 0
+	}
+
+	ms, err := strconv.Atoi(raw)
+	if err != nil || ms < 0 {
+		slog.Warn("Invalid DB_NODE_CACHE_TTL_MS. Node cache disabled.", "value", raw)
+		return 0
+	}
+
+	return time.Duration(ms) * time.Millisecond
+}
+
+func getPushgatewayInterval() time.Duration {
+	raw := strings.TrimSpace(os.Getenv("PUSHGATEWAY_INTERVAL_MS"))
+	if raw == "" {
+		return defaultPushgatewayInterval
+	}
+
+	ms, err := strconv.Atoi(raw)
+	if err != nil || ms <= 0 {
+		slog.Warn("Invalid PUSHGATEWAY_INTERVAL_MS. Using default.", "value", raw, "default", defaultPushgatewayInterval)
+		return defaultPushgatewayInterval
+	}
+
+	return time.Duration(ms) * time.Millisecond
+}
+
+func getRemoteWriteInterval() time.Duration {
+	raw := strings.TrimSpace(os.Getenv("REMOTE_WRITE_INTERVAL_MS"))
+	if raw == "" {
+		return defaultRemoteWriteInterval
+	}
+
+	ms, err := strconv.Atoi(raw)
+	if err != nil || ms <= 0 {
+		slog.Warn("Invalid REMOTE_WRITE_INTERVAL_MS. Using default.", "value", raw, "default", defaultRemoteWriteInterval)
+		return defaultRemoteWriteInterval
+	}
+
+	return time.Duration(ms) * time.Millisecond
+}
+
+// getRemoteWriteHeaders parses REMOTE_WRITE_HEADERS, a comma-separated list
+// of Name=value pairs such as "Authorization=Bearer abc,X-Scope-OrgID=team".
+// Malformed pairs are skipped with a warning.
+func getRemoteWriteHeaders() http.Header {
+	headers := make(http.Header)
+	for _, pair := range strings.Split(os.Getenv("REMOTE_WRITE_HEADERS"), ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			slog.Warn("Invalid REMOTE_WRITE_HEADERS entry. Skipping.", "entry", pair)
+			continue
+		}
+		headers.Add(name, strings.TrimSpace(value))
+	}
+	return headers
+}
+
+// newWatchdogFromEnv builds a Watchdog when WATCHDOG_INTERVAL_MS is set, or
+// returns nil when the watchdog is disabled.
+func newWatchdogFromEnv(store CounterStore) *Watchdog {
+	raw := strings.TrimSpace(os.Getenv("WATCHDOG_INTERVAL_MS"))
+	if raw == "" {
+		return nil
+	}
+	intervalMS, err := strconv.Atoi(raw)
+	if err != nil || intervalMS <= 0 {
+		slog.Warn("Invalid WATCHDOG_INTERVAL_MS. Watchdog disabled.", "value", raw)
+		return nil
+	}
+
+	timeout := defaultWatchdogTimeout
+	if raw := strings.TrimSpace(os.Getenv("WATCHDOG_TIMEOUT_MS")); raw != "" {
+		if ms, err := strconv.Atoi(raw); err == nil && ms > 0 {
+			timeout = time.Duration(ms) * time.Millisecond
+		} else {
+			slog.Warn("Invalid WATCHDOG_TIMEOUT_MS. Using default.", "value", raw, "default", defaultWatchdogTimeout)
+		}
+	}
+
+	threshold := defaultWatchdogFailureThreshold
+	if raw := strings.TrimSpace(os.Getenv("WATCHDOG_FAILURE_THRESHOLD")); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil && n > 0 {
+			threshold = n
+		} else {
+			slog.Warn("Invalid WATCHDOG_FAILURE_THRESHOLD. Using default.", "value", raw, "default", defaultWatchdogFailureThreshold)
+		}
+	}
+
+	exit := false
+	switch action := strings.TrimSpace(os.Getenv("WATCHDOG_ACTION")); action {
+	case "", "unhealthy":
+	case "exit":
+		exit = true
+	default:
+		slog.Warn("Invalid WATCHDOG_ACTION. Using 'unhealthy'.", "value", action)
+	}
+
+	interval := time.Duration(intervalMS) * time.Millisecond
+	slog.Info("Watchdog probing the store", "interval", interval, "timeout", timeout, "threshold", threshold, "exit", exit)
+	return NewWatchdog(store, interval, timeout, threshold, exit)
+}
+
+func getBucketGranularity() time.Duration {
+	raw := strings.TrimSpace(os.Getenv("BUCKET_GRANULARITY_MS"))
+	if raw == "" {
+		return defaultBucketGranularity
+	}
+
+	ms, err := strconv.Atoi(raw)
+	if err != nil || ms <= 0 {
+		slog.Warn("Invalid BUCKET_GRANULARITY_MS. Using default.", "value", raw, "default", defaultBucketGranularity)
+		return defaultBucketGranularity
+	}
+
+	return time.Duration(ms) * time.Millisecond
+}
+
+func getBucketRetention(granularity time.Duration) time.Duration {
+	raw := strings.TrimSpace(os.Getenv("BUCKET_RETENTION_MS"))
+	if raw == "" {
+		return defaultBucketRetention
+	}
+
+	ms, err := strconv.Atoi(raw)
+	if err != nil || time.Duration(ms)*time.Millisecond < granularity {
+		slog.Warn("Invalid BUCKET_RETENTION_MS (must be at least the bucket granularity). Using default.", "value", raw, "default", defaultBucketRetention)
+		return defaultBucketRetention
+	}
+
+	return time.Duration(ms) * time.Millisecond
+}
+
+func getMetricsExporter() string {
+	exporter := strings.ToLower(strings.TrimSpace(os.Getenv("METRICS_EXPORTER")))
+	switch exporter {
+	case "":
+		return metricsExporterPrometheus
+	case metricsExporterPrometheus, metricsExporterOTLP, metricsExporterNone:
+		return exporter
+	default:
+		slog.Warn("Invalid METRICS_EXPORTER. Using default.", "value", exporter, "default", metricsExporterPrometheus)
+		return metricsExporterPrometheus
+	}
+}
+
+// getTraceSampleRatio reads TRACE_SAMPLE_RATIO, the fraction of new traces
+// to sample, from 0 to 1.
+func getTraceSampleRatio() float64 {
+	raw := strings.TrimSpace(os.Getenv("TRACE_SAMPLE_RATIO"))
+	if raw == "" {
+		return defaultTraceSampleRatio
+	}
+
+	ratio, err := strconv.ParseFloat(raw, 64)
+	if err != nil || ratio < 0 || ratio > 1 {
+		slog.Warn("Invalid TRACE_SAMPLE_RATIO. Using default.", "value", raw, "default", defaultTraceSampleRatio)
+		return defaultTraceSampleRatio
+	}
+
+	return ratio
+}
+
+// newRateLimiterFromEnv builds a RateLimiter when the rpsKey variable is set,
+// or returns nil when that limit is disabled. The burstKey variable defaults
+// to one second's worth of requests. keyedBy names what each bucket is for,
+// for the startup line.
+func newRateLimiterFromEnv(rpsKey, burstKey, keyedBy string) *RateLimiter {
+	raw := strings.TrimSpace(os.Getenv(rpsKey))
+	if raw == "" {
+		return nil
+	}
+	rps, err := strconv.ParseFloat(raw, 64)
+	if err != nil || rps <= 0 || math.IsInf(rps, 0) {
+		slog.Warn("Invalid "+rpsKey+". Limit disabled.", "value", raw)
+		return nil
+	}
+
+	defaultBurst := max(1, int(math.Ceil(rps)))
+	burst := defaultBurst
+	if raw := strings.TrimSpace(os.Getenv(burstKey)); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil && n > 0 {
+			burst = n
+		} else {
+			slog.Warn("Invalid "+burstKey+". Using default.", "value", raw, "default", defaultBurst)
+		}
+	}
+
+	slog.Info("Rate limiting enabled", "keyed_by", keyedBy, "rps", rps, "burst", burst)
+	return NewRateLimiter(rps, burst)
+}
+
+// newFairQueueFromEnv builds a FairQueue when FAIR_QUEUE_CONCURRENCY is set,
+// or returns nil when queueing is disabled.
+func newFairQueueFromEnv() *FairQueue {
+	raw := strings.TrimSpace(os.Getenv("FAIR_QUEUE_CONCURRENCY"))
+	if raw == "" {
+		return nil
+	}
+	concurrency, err := strconv.Atoi(raw)
+	if err != nil || concurrency <= 0 {
+		slog.Warn("Invalid FAIR_QUEUE_CONCURRENCY. Fair queue disabled.", "value", raw)
+		return nil
+	}
+
+	depth := defaultFairQueueDepth
+	if raw := strings.TrimSpace(os.Getenv("FAIR_QUEUE_DEPTH")); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil && n >= 0 {
+			depth = n
+		} else {
+			slog.Warn("Invalid FAIR_QUEUE_DEPTH. Using default.", "value", raw, "default", defaultFairQueueDepth)
+		}
+	}
+
+	perClient := defaultFairQueuePerClient
+	if raw := strings.TrimSpace(os.Getenv("FAIR_QUEUE_PER_CLIENT")); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil && n > 0 {
+			perClient = n
+		} else {
+			slog.Warn("Invalid FAIR_QUEUE_PER_CLIENT. Using default.", "value", raw, "default", defaultFairQueuePerClient)
+		}
+	}
+
+	timeout := defaultFairQueueTimeout
+	if raw := strings.TrimSpace(os.Getenv("FAIR_QUEUE_TIMEOUT_MS")); raw != "" {
+		if ms, err := strconv.Atoi(raw); err == nil && ms > 0 {
+			timeout = time.Duration(ms) * time.Millisecond
+		} else {
+			slog.Warn("Invalid FAIR_QUEUE_TIMEOUT_MS. Using default.", "value", raw, "default", defaultFairQueueTimeout)
+		}
+	}
+
+	slog.Info("Fair queue enabled", "concurrency", concurrency, "depth", depth, "per_client", perClient, "timeout", timeout)
+	return NewFairQueue(concurrency, depth, perClient, timeout)
+}
+
+// newCountSignerFromEnv returns the signer for X-Count-Token headers, or nil
+// when COUNT_TOKEN_KEY is unset and tokens are disabled.
+func newCountSignerFromEnv() *CountSigner {
+	key := os.Getenv("COUNT_TOKEN_KEY")
+	if key == "" {
+		return nil
+	}
+
+	alg := strings.ToUpper(strings.TrimSpace(getEnvOrDefault("COUNT_TOKEN_ALG", tokenAlgHS256)))
+
+	ttl := defaultCountTokenTTL
+	if raw := strings.TrimSpace(os.Getenv("COUNT_TOKEN_TTL_MS")); raw != "" {
+		if ms, err := strconv.Atoi(raw); err == nil && ms > 0 {
+			ttl = time.Duration(ms) * time.Millisecond
+		} else {
+			slog.Warn("Invalid COUNT_TOKEN_TTL_MS. Using default.", "value", raw, "default", defaultCountTokenTTL)
+		}
+	}
+
+	signer, err := NewCountSigner(alg, []byte(key), ttl)
+	if err != nil {
+		fatal("Invalid count token configuration", "error", err)
+	}
+	slog.Info("Signing counts", "alg", alg, "ttl", ttl)
+	return signer
+}
+
+// newStreamConsumerFromEnv returns a consumer for REDIS_STREAM, or nil when it
+// is unset. The stream's Redis is REDIS_STREAM_URL, falling back to
+// REDIS_URL.
+func newStreamConsumerFromEnv(store CounterStore, timeout time.Duration, watcher *CountWatcher, buckets *BucketRecorder) *StreamConsumer {
+	stream := strings.TrimSpace(os.Getenv("REDIS_STREAM"))
+	if stream == "" {
+		return nil
+	}
+
+	redisURL := strings.TrimSpace(os.Getenv("REDIS_STREAM_URL"))
+	if redisURL == "" {
+		redisURL = strings.TrimSpace(os.Getenv("REDIS_URL"))
+	}
+	if redisURL == "" {
+		fatal("REDIS_STREAM_URL or REDIS_URL must be set when REDIS_STREAM is set")
+	}
+	group := getEnvOrDefault("REDIS_STREAM_GROUP", defaultStreamGroup)
+	consumer := strings.TrimSpace(os.Getenv("REDIS_STREAM_CONSUMER"))
+	if consumer == "" {
+		consumer, _ = os.Hostname()
+	}
+
+	streamConsumer, err := NewStreamConsumer(redisURL, stream, group, consumer, store, timeout, watcher, buckets)
+	if err != nil {
+		fatal("Invalid Redis stream URL", "error", err)
+	}
+	slog.Info("Consuming increments from Redis stream", "stream", stream, "group", group, "consumer", consumer)
+	return streamConsumer
+}
+
+// newDBNodeShedderFromEnv returns a shedder for db_node lookups, or nil when
+// neither DB_NODE_SHED_IN_FLIGHT nor DB_NODE_SHED_POOL_PERCENT is set.
+func newDBNodeShedderFromEnv(store CounterStore) *DBNodeShedder {
+	var inFlightLimit int64
+	if raw := strings.TrimSpace(os.Getenv("DB_NODE_SHED_IN_FLIGHT")); raw != "" {
+		if n, err := strconv.ParseInt(raw, 10, 64); err == nil && n > 0 {
+			inFlightLimit = n
+		} else {
+			slog.Warn("Invalid DB_NODE_SHED_IN_FLIGHT. In-flight shedding disabled.", "value", raw)
+		}
+	}
+
+	var poolPercent int
+	var pool PoolStatser
+	if raw := strings.TrimSpace(os.Getenv("DB_NODE_SHED_POOL_PERCENT")); raw != "" {
+		n, err := strconv.Atoi(raw)
+		statser, ok := store.(PoolStatser)
+		switch {
+		case err != nil || n <= 0 || n > 100:
+			slog.Warn("Invalid DB_NODE_SHED_POOL_PERCENT. Pool shedding disabled.", "value", raw)
+		case !ok:
+			slog.Warn("DB_NODE_SHED_POOL_PERCENT is only supported in cockroach mode and is ignored.")
+		default:
+			if _, maxOpen := statser.PoolUtilization(); maxOpen == 0 {
+				slog.Warn("DB_NODE_SHED_POOL_PERCENT needs DB_MAX_OPEN_CONNS to measure utilization and is ignored.")
+			} else {
+				poolPercent, pool = n, statser
+			}
+		}
+	}
+
+	if inFlightLimit == 0 && pool == nil {
+		return nil
+	}
+	poolLimit := "unlimited"
+	if poolPercent > 0 {
+		poolLimit = strconv.Itoa(poolPercent) + "%"
+	}
+	slog.Info("Skipping db_node lookups under load", "in_flight_limit", limitString(int(inFlightLimit)), "pool_limit", poolLimit)
+	return NewDBNodeShedder(inFlightLimit, poolPercent, pool)
+}
+
+// getHeartbeatInterval returns the HEARTBEAT_INTERVAL_MS interval, or zero
+// when heartbeats are disabled.
+func getHeartbeatInterval() time.Duration {
+	raw := strings.TrimSpace(os.Getenv("HEARTBEAT_INTERVAL_MS"))
+	if raw == "" {
+		return 0
+	}
+
+	ms, err := strconv.Atoi(raw)
+	if err != nil || ms <= 0 {
+		slog.Warn("Invalid HEARTBEAT_INTERVAL_MS. Heartbeat disabled.", "value", raw)
+		return 0
+	}
+
+	return time.Duration(ms) * time.Millisecond
+}
+
+func getSeedTimeout() time.Duration {
+	raw := strings.TrimSpace(os.Getenv("SEED_TIMEOUT_MS"))
+	if raw == "" {
+		return defaultSeedTimeout
+	}
+
+	ms, err := strconv.Atoi(raw)
+	if err != nil || ms <= 0 {
+		slog.Warn("Invalid SEED_TIMEOUT_MS. Using default.", "value", raw, "default", defaultSeedTimeout)
+		return defaultSeedTimeout
+	}
+
+	return time.Duration(ms) * time.Millisecond
+}
+
+// getPollTimeout returns how long POLL_TIMEOUT_MS lets /poll wait for a
+// change.
+func getPollTimeout() time.Duration {
+	raw := strings.TrimSpace(os.Getenv("POLL_TIMEOUT_MS"))
+	if raw == "" {
+		return defaultPollTimeout
+	}
+
+	ms, err := strconv.Atoi(raw)
+	if err != nil || ms <= 0 {
+		slog.Warn("Invalid POLL_TIMEOUT_MS. Using default.", "value", raw, "default", defaultPollTimeout)
+		return defaultPollTimeout
+	}
+
+	return time.Duration(ms) * time.Millisecond
+}
+
+// getPollMaxWaiters returns the POLL_MAX_WAITERS limit on /poll requests
+// waiting at once. Zero means unlimited.
+func getPollMaxWaiters() int64 {
+	raw := strings.TrimSpace(os.Getenv("POLL_MAX_WAITERS"))
+	if raw == "" {
+		return defaultPollMaxWaiters
+	}
+
+	n, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || n < 0 {
+		slog.Warn("Invalid POLL_MAX_WAITERS. Using default.", "value", raw, "default", defaultPollMaxWaiters)
+		return defaultPollMaxWaiters
+	}
+
+	return n
+}
+
+// getPollCheckInterval returns how often POLL_CHECK_INTERVAL_MS has /poll
+// re-read the store for changes made by other instances.
+func getPollCheckInterval() time.Duration {
+	raw := strings.TrimSpace(os.Getenv("POLL_CHECK_INTERVAL_MS"))
+	if raw == "" {
+		return defaultPollCheckInterval
+	}
+
+	ms, err := strconv.Atoi(raw)
+	if err != nil || ms <= 0 {
+		slog.Warn("Invalid POLL_CHECK_INTERVAL_MS. Using default.", "value", raw, "default", defaultPollCheckInterval)
+		return defaultPollCheckInterval
+	}
+
+	return time.Duration(ms) * time.Millisecond
+}
+
+// getPeerTimeout returns how long PEER_TIMEOUT_MS lets /cluster wait for
+// each peer.
+func getPeerTimeout() time.Duration {
+	raw := strings.TrimSpace(os.Getenv("PEER_TIMEOUT_MS"))
+	if raw == "" {
+		return defaultPeerTimeout
+	}
+
+	ms, err := strconv.Atoi(raw)
+	if err != nil || ms <= 0 {
+		slog.Warn("Invalid PEER_TIMEOUT_MS. Using default.", "value", raw, "default", defaultPeerTimeout)
+		return defaultPeerTimeout
+	}
+
+	return time.Duration(ms) * time.Millisecond
+}
+
+// getPort returns PORT, defaulting to 9001. An invalid port is fatal, since
+// there is nothing sensible to listen on instead.
+func getPort() string {
+	raw := strings.TrimSpace(os.Getenv("PORT"))
+	if raw == "" {
+		return defaultPort
+	}
+
+	port, err := strconv.Atoi(raw)
+	if err != nil || port < 1 || port > 65535 {
+		fatal("Invalid PORT. It must be an integer from 1 to 65535.", "value", raw)
+	}
+
+	return strconv.Itoa(port)
+}
+
+// getInitialCount returns INITIAL_COUNT, the value the in-memory default
+// counter starts at, so counts keep growing across restarts. It defaults to 0.
+func getInitialCount() int64 {
+	raw := strings.TrimSpace(os.Getenv("INITIAL_COUNT"))
+	if raw == "" {
+		return 0
+	}
+
+	initial, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		slog.Warn("Invalid INITIAL_COUNT. Using default.", "value", raw, "default", 0)
+		return 0
+	}
+
+	slog.Info("Starting the count", "initial", initial)
+	return initial
+}
+
+// getErrorLogInterval returns ERROR_LOG_INTERVAL_MS, the least time between
+// two log lines for the same store error. 0 logs every error.
+func getErrorLogInterval() time.Duration {
+	raw := strings.TrimSpace(os.Getenv("ERROR_LOG_INTERVAL_MS"))
+	if raw == "" {
+		return defaultErrorLogInterval
+	}
+
+	ms, err := strconv.Atoi(raw)
+	if err != nil || ms < 0 {
+		slog.Warn("Invalid ERROR_LOG_INTERVAL_MS. Using default.", "value", raw, "default", defaultErrorLogInterval)
+		return defaultErrorLogInterval
+	}
+
+	return time.Duration(ms) * time.Millisecond
+}
+
+// getDBBootstrapRetries returns DB_BOOTSTRAP_RETRIES, how many times schema
+// creation is retried after conflicting with another instance. 0 disables
+// retries.
+func getDBBootstrapRetries() int {
+	raw := strings.TrimSpace(os.Getenv("DB_BOOTSTRAP_RETRIES"))
+	if raw == "" {
+		return defaultBootstrapRetries
+	}
+
+	retries, err := strconv.Atoi(raw)
+	if err != nil || retries < 0 {
+		slog.Warn("Invalid DB_BOOTSTRAP_RETRIES. Using default.", "value", raw, "default", defaultBootstrapRetries)
+		return defaultBootstrapRetries
+	}
+
+	return retries
+}
+
+// getDBMaxRetries returns DB_MAX_RETRIES, how many times a failed write is
+// retried. 0 disables retries.
+func getDBMaxRetries() int {
+	raw := strings.TrimSpace(os.Getenv("DB_MAX_RETRIES"))
+	if raw == "" {
+		return defaultDBMaxRetries
+	}
+
+	retries, err := strconv.Atoi(raw)
+	if err != nil || retries < 0 {
+		slog.Warn("Invalid DB_MAX_RETRIES. Using default.", "value", raw, "default", defaultDBMaxRetries)
+		return defaultDBMaxRetries
+	}
+
+	return retries
+}
+
+func getDBRetryBase() time.Duration {
+	raw := strings.TrimSpace(os.Getenv("DB_RETRY_BASE_MS"))
+	if raw == "" {
+		return defaultDBRetryBase
+	}
+
+	ms, err := strconv.Atoi(raw)
+	if err != nil || ms <= 0 {
+		slog.Warn("Invalid DB_RETRY_BASE_MS. Using default.", "value", raw, "default", defaultDBRetryBase)
+		return defaultDBRetryBase
+	}
+
+	return time.Duration(ms) * time.Millisecond
+}
+
+// getTLSFiles returns TLS_CERT_FILE and TLS_KEY_FILE, which must be set
+// together to serve HTTPS. The pair is loaded once here so a bad path or
+// mismatched key fails startup with a clear message. Both empty means plain
+// HTTP.
+func getTLSFiles() (certFile, keyFile string) {
+	certFile = strings.TrimSpace(os.Getenv("TLS_CERT_FILE"))
+	keyFile = strings.TrimSpace(os.Getenv("TLS_KEY_FILE"))
+	if certFile == "" && keyFile == "" {
+		return "", ""
+	}
+	if certFile == "" || keyFile == "" {
+		fatal("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if _, err := tls.LoadX509KeyPair(certFile, keyFile); err != nil {
+		fatal("Failed to load TLS certificate", "cert_file", certFile, "key_file", keyFile, "error", err)
+	}
+	return certFile, keyFile
+}
+
+// getTLSConfig builds the listener's tls.Config from TLS_MIN_VERSION and
+// TLS_CIPHER_SUITES. Unknown or weak values fail startup rather than quietly
+// serving something a security scan would reject.
+func getTLSConfig() *tls.Config {
+	minVersion, err := parseTLSMinVersion(os.Getenv("TLS_MIN_VERSION"))
+	if err != nil {
+		fatal("Invalid TLS configuration", "error", err)
+	}
+	suites, err := parseTLSCipherSuites(os.Getenv("TLS_CIPHER_SUITES"))
 	if err != nil {
-		return "", err
+		fatal("Invalid TLS configuration", "error", err)
+	}
+	if minVersion == tls.VersionTLS13 && strings.TrimSpace(os.Getenv("TLS_CIPHER_SUITES")) != "" {
+		slog.Warn("TLS_CIPHER_SUITES has no effect with TLS_MIN_VERSION=1.3; TLS 1.3 suites are not configurable.")
 	}
-	return fmt.Sprintf("Node %d", nodeID), nil
+	return &tls.Config{MinVersion: minVersion, CipherSuites: suites}
 }
 
-func writeJSON(w http.ResponseWriter, payload Count) {
-	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(payload)
-}
+// getShutdownTimeout returns how long SHUTDOWN_TIMEOUT_MS lets in-flight
+// requests drain before the server stops waiting for them.
+func getShutdownTimeout() time.Duration {
+	raw := strings.TrimSpace(os.Getenv("SHUTDOWN_TIMEOUT_MS"))
+	if raw == "" {
+		return defaultShutdownTimeout
+	}
 
-func getCustomDNSServer() string {
-	dnsServer := strings.TrimSpace(os.Getenv("DNS_SERVER"))
-	if dnsServer != "" {
-		return dnsServer
+	ms, err := strconv.Atoi(raw)
+	if err != nil || ms <= 0 {
+		slog.Warn("Invalid SHUTDOWN_TIMEOUT_MS. Using default.", "value", raw, "default", defaultShutdownTimeout)
+		return defaultShutdownTimeout
 	}
-	return strings.TrimSpace(os.Getenv("CONSUL_DNS_ADDR"))
+
+	return time.Duration(ms) * time.Millisecond
 }
 
-func getCustomDNSNetwork() string {
-	dnsNetwork := strings.TrimSpace(os.Getenv("DNS_NETWORK"))
-	if dnsNetwork == "" {
-		return defaultDNSNetwork
+func getMaxCounterNameLen() int {
+	raw := strings.TrimSpace(os.Getenv("MAX_COUNTER_NAME_LEN"))
+	if raw == "" {
+		return defaultMaxCounterNameLen
 	}
-	return dnsNetwork
+
+	n, err := strconv.Atoi(raw)
+	if err != nil || n <= 0 {
+		slog.Warn("Invalid MAX_COUNTER_NAME_LEN. Using default.", "value", raw, "default", defaultMaxCounterNameLen)
+		return defaultMaxCounterNameLen
+	}
+
+	return n
 }
 
-func getCustomDNSTimeout() time.Duration {
-	raw := strings.TrimSpace(os.Getenv("DNS_TIMEOUT_MS"))
+func getTrustedProxyHops() int {
+	raw := strings.TrimSpace(os.Getenv("TRUSTED_PROXY_HOPS"))
 	if raw == "" {
-		return defaultDNSTimeout
+		return 0
 	}
 
-	ms, err := strconv.Atoi(raw)
-	if err != nil || ms <= 0 {
-		log.Printf("Invalid DNS_TIMEOUT_MS=%q. Using default %s.", raw, defaultDNSTimeout)
-		return defaultDNSTimeout
+	hops, err := strconv.Atoi(raw)
+	if err != nil || hops < 0 {
+		slog.Warn("Invalid TRUSTED_PROXY_HOPS. Using the peer address as the client IP.", "value", raw)
+		return 0
 	}
 
-	return time.Duration(ms) * time.Millisecond
+	return hops
 }
 
-func getDBRequestTimeout() time.Duration {
-	raw := strings.TrimSpace(os.Getenv("DB_REQUEST_TIMEOUT_MS"))
+// getCountFormatSeparator returns the thousands separator used for
+// ?format=locale. It is not trimmed so that a space can be configured.
+func getCountFormatSeparator() string {
+	if sep := os.Getenv("COUNT_FORMAT_SEPARATOR"); sep != "" {
+		return sep
+	}
+	return defaultCountFormatSeparator
+}
+
+func getCountDisplayFloor() int64 {
+	raw := strings.TrimSpace(os.Getenv("COUNT_DISPLAY_FLOOR"))
 	if raw == "" {
-		return defaultDBRequestTimeout
+		return 0
 	}
 
-	ms, err := strconv.Atoi(raw)
-	if err != nil || ms <= 0 {
-		log.Printf("Invalid DB_REQUEST_TIMEOUT_MS=%q. Using default %s.", raw, defaultDBRequestTimeout)
-		return defaultDBRequestTimeout
+	floor, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || floor < 0 {
+		slog.Warn("Invalid COUNT_DISPLAY_FLOOR. Display floor disabled.", "value", raw)
+		return 0
 	}
 
-	return time.Duration(ms) * time.Millisecond
+	return floor
+}
+
+// displayCount applies the presentation-only display floor to a count read
+// from the store. The stored value is never changed. A floor of 0 disables
+// it, so decremented counts below zero are shown as they are.
+func displayCount(count, floor int64) int64 {
+	if floor > 0 && count < floor {
+		return floor
+	}
+	return count
 }
 
 func normalizeDNSServerAddr(dnsServer string) string {
@@ -158,7 +1684,7 @@ func normalizeDNSServerAddr(dnsServer string) string {
 	return net.JoinHostPort(dnsServer, defaultDNSPort)
 }
 
-func resolveDNSServerHostToIP(dnsServer string) string {
+func resolveDNSServerHostToIP(dnsServer string, preferIPv6 bool) string {
 	host, port, err := net.SplitHostPort(dnsServer)
 	if err != nil {
 		return dnsServer
@@ -168,21 +1694,25 @@ func resolveDNSServerHostToIP(dnsServer string) string {
 		return dnsServer
 	}
 
-	ips, lookupErr := net.LookupIP(host)
+	ips, lookupErr := dnsServerIPs.LookupIP(host)
 	if lookupErr != nil || len(ips) == 0 {
-		log.Printf("Unable to resolve DNS server host %q: %v. Using as-is.", host, lookupErr)
+		slog.Warn("Unable to resolve DNS server host. Using as-is.", "host", host, "error", lookupErr)
 		return dnsServer
 	}
 
-	selectedIP := ips[0]
+	return net.JoinHostPort(selectDNSServerIP(ips, preferIPv6).String(), port)
+}
+
+// selectDNSServerIP picks the first IPv4 address, or the first IPv6 address
+// when preferIPv6 is set, falling back to the first address when none of
+// the preferred family was resolved.
+func selectDNSServerIP(ips []net.IP, preferIPv6 bool) net.IP {
 	for _, ip := range ips {
-		if ip.To4() != nil {
-			selectedIP = ip
-			break
+		if (ip.To4() == nil) == preferIPv6 {
+			return ip
 		}
 	}
-
-	return net.JoinHostPort(selectedIP.String(), port)
+	return ips[0]
 }
 
 func configureCustomDNSResolver() {
@@ -192,7 +1722,7 @@ func configureCustomDNSResolver() {
 	}
 
 	dnsServer = normalizeDNSServerAddr(dnsServer)
-	dnsServer = resolveDNSServerHostToIP(dnsServer)
+	dnsServer = resolveDNSServerHostToIP(dnsServer, getEnvBool("DNS_PREFER_IPV6", false))
 	dnsNetwork := getCustomDNSNetwork()
 	dnsTimeout := getCustomDNSTimeout()
 
@@ -204,64 +1734,626 @@ func configureCustomDNSResolver() {
 		},
 	}
 
-	log.Printf("Custom DNS resolver enabled: %s://%s", dnsNetwork, dnsServer)
+	slog.Info("Custom DNS resolver enabled", "network", dnsNetwork, "server", dnsServer)
 }
 
 func main() {
+	setupLogging()
+	slog.Info("Starting", "version", version, "commit", gitCommit, "build_time", buildTime)
+	dnsServerIPs = newIPCache(net.LookupIP, getDNSCacheTTL())
 	configureCustomDNSResolver()
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "9001"
-	}
+	port := getPort()
 	portWithColon := fmt.Sprintf(":%s", port)
 
+	// Configuration that can fail startup is read before the store opens.
+	certFile, keyFile := getTLSFiles()
+	var tlsConfig *tls.Config
+	if certFile != "" {
+		tlsConfig = getTLSConfig()
+	} else if os.Getenv("TLS_MIN_VERSION") != "" || os.Getenv("TLS_CIPHER_SUITES") != "" {
+		slog.Warn("TLS_MIN_VERSION and TLS_CIPHER_SUITES are ignored without TLS_CERT_FILE and TLS_KEY_FILE.")
+	}
+	maxCounterNameLen = getMaxCounterNameLen()
+	allowedCounters = parseAllowedCounters(os.Getenv("ALLOWED_COUNTERS"))
+	var weightedRoutes []WeightedRoute
+	if path := strings.TrimSpace(os.Getenv("WEIGHTED_ROUTES_FILE")); path != "" {
+		var err error
+		weightedRoutes, err = loadWeightedRoutes(path)
+		if err != nil {
+			fatal("Failed to load WEIGHTED_ROUTES_FILE", "error", err)
+		}
+	}
+	requiredHeaderName := strings.TrimSpace(os.Getenv("REQUIRED_HEADER_NAME"))
+	requiredHeaderValue := os.Getenv("REQUIRED_HEADER_VALUE")
+	if requiredHeaderName != "" && requiredHeaderValue == "" {
+		fatal("REQUIRED_HEADER_VALUE must be set when REQUIRED_HEADER_NAME is set")
+	}
+
+	metricsExporter := getMetricsExporter()
+	shutdownMetrics, err := setupMetrics(context.Background(), metricsExporter)
+	if err != nil {
+		fatal("Failed to set up metrics", "error", err)
+	}
+	traceSampleRatio := getTraceSampleRatio()
+	shutdownTracing, tracingEnabled, err := setupTracing(context.Background(), traceSampleRatio)
+	if err != nil {
+		fatal("Failed to set up tracing", "error", err)
+	}
+	if tracingEnabled {
+		slog.Info("Exporting traces", "endpoint", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), "sample_ratio", traceSampleRatio)
+	}
+
 	var store CounterStore
 	storageMode := os.Getenv("STORAGE_MODE")
 
 	switch storageMode {
 	case "", "memory":
-		fmt.Println("Starting in Standalone Mode (In-Memory)")
-		store = &InMemoryStore{}
+		slog.Info("Starting in Standalone Mode (In-Memory)")
+		if os.Getenv("COUNT_HMAC_KEY") != "" {
+			slog.Warn("COUNT_HMAC_KEY is ignored in memory mode, where the count cannot be edited out-of-band.")
+		}
+		storageMode = "memory"
+		store = NewInMemoryStore(getInitialCount())
 	case "cockroach":
-		pgURL := os.Getenv("PG_URL")
+		pgURL := getEnvOrDefault("PG_URL_WRITE", os.Getenv("PG_URL"))
 		if pgURL == "" {
-			log.Fatal("PG_URL must be set when STORAGE_MODE=cockroach")
+			fatal("PG_URL (or PG_URL_WRITE) must be set when STORAGE_MODE=cockroach")
+		}
+		readPGURL := os.Getenv("PG_URL_READ")
+		var hmacKey []byte
+		if key := os.Getenv("COUNT_HMAC_KEY"); key != "" {
+			hmacKey = []byte(key)
+			slog.Info("Verifying the count against an HMAC checksum")
 		}
 
-		fmt.Printf("Connecting to CockroachDB at %s\n", pgURL)
-		cockroachStore, err := NewCockroachStore(pgURL)
+		pool := getDBPoolConfig()
+		slog.Info("Connecting to CockroachDB", "url", pgURL)
+		slog.Info("DB pool", "max_open", limitString(pool.MaxOpenConns), "max_idle", pool.MaxIdleConns,
+			"max_lifetime", durationLimitString(pool.ConnMaxLifetime), "max_idle_time", durationLimitString(pool.ConnMaxIdleTime))
+		if readPGURL != "" {
+			slog.Info("Reading from CockroachDB", "url", readPGURL)
+		}
+		// The URLs above are printed before secrets are filled in.
+		resolvedPGURL, err := resolveVaultSecrets(context.Background(), pgURL)
+		if err != nil {
+			fatal("Failed to resolve secrets in PG_URL", "error", err)
+		}
+		resolvedReadPGURL, err := resolveVaultSecrets(context.Background(), readPGURL)
+		if err != nil {
+			fatal("Failed to resolve secrets in PG_URL_READ", "error", err)
+		}
+		cockroachStore, err := NewCockroachStore(CockroachConfig{
+			PGURL:              resolvedPGURL,
+			ReadPGURL:          resolvedReadPGURL,
+			ConnAcquireTimeout: getDBConnAcquireTimeout(),
+			ConnectTimeout:     getDBConnectTimeout(),
+			QueryComments:      strings.EqualFold(strings.TrimSpace(os.Getenv("DB_QUERY_COMMENTS")), "true"),
+			NodeCacheTTL:       getDBNodeCacheTTL(),
+			Pool:               pool,
+			RecreateMissingRow: !strings.EqualFold(strings.TrimSpace(os.Getenv("DB_RECREATE_MISSING_ROW")), "false"),
+			ValidateOnCheckout: getEnvBool("DB_VALIDATE_ON_CHECKOUT", false),
+			BootstrapRetries:   getDBBootstrapRetries(),
+			MaxRetries:         getDBMaxRetries(),
+			RetryBase:          getDBRetryBase(),
+			HMACKey:            hmacKey,
+			StatementMetrics:   getEnvBool("DB_STATEMENT_METRICS", false),
+		})
 		if err != nil {
-			log.Fatalf("Failed to initialize CockroachDB store: %v", err)
+			fatal("Failed to initialize CockroachDB store", "error", err)
 		}
 		store = cockroachStore
+		// Close the pool if the startup check below fails.
+		fatalCloser = cockroachStore
+		if query := strings.TrimSpace(os.Getenv("DB_STARTUP_CHECK_QUERY")); query != "" {
+			slog.Info("Running DB_STARTUP_CHECK_QUERY")
+			checkCtx, cancel := context.WithTimeout(context.Background(), defaultStartupCheckTimeout)
+			err := cockroachStore.StartupCheck(checkCtx, query)
+			cancel()
+			if err != nil {
+				if hint := startupCheckHint(err); hint != "" {
+					fatal("DB_STARTUP_CHECK_QUERY failed", "error", err, "hint", hint)
+				}
+				fatal("DB_STARTUP_CHECK_QUERY failed", "error", err)
+			}
+		}
+		if metricsExporter == metricsExporterPrometheus {
+			prometheus.MustRegister(collectors.NewDBStatsCollector(cockroachStore.db, "counting"))
+			if cockroachStore.readDB != cockroachStore.db {
+				prometheus.MustRegister(collectors.NewDBStatsCollector(cockroachStore.readDB, "counting_read"))
+			}
+		}
+	case "redis":
+		redisURL := os.Getenv("REDIS_URL")
+		if redisURL == "" {
+			fatal("REDIS_URL must be set when STORAGE_MODE=redis")
+		}
+		if os.Getenv("COUNT_HMAC_KEY") != "" {
+			slog.Warn("COUNT_HMAC_KEY is only supported in cockroach mode and is ignored.")
+		}
+		redisKey := getEnvOrDefault("REDIS_KEY", defaultRedisKey)
+
+		slog.Info("Connecting to Redis", "url", redactURL(redisURL), "key", redisKey)
+		redisStore, err := NewRedisStore(redisURL, redisKey)
+		if err != nil {
+			fatal("Failed to initialize Redis store", "error", err)
+		}
+		store = redisStore
+	case "sqlite":
+		sqlitePath := os.Getenv("SQLITE_PATH")
+		if sqlitePath == "" {
+			fatal("SQLITE_PATH must be set when STORAGE_MODE=sqlite")
+		}
+		if os.Getenv("COUNT_HMAC_KEY") != "" {
+			slog.Warn("COUNT_HMAC_KEY is only supported in cockroach mode and is ignored.")
+		}
+
+		slog.Info("Using SQLite database", "path", sqlitePath)
+		sqliteStore, err := NewSQLiteStore(sqlitePath)
+		if err != nil {
+			fatal("Failed to initialize SQLite store", "error", err)
+		}
+		store = sqliteStore
 	default:
-		fmt.Printf("Warning: STORAGE_MODE=%s is not supported. Defaulting to 'memory'.\n", storageMode)
-		store = &InMemoryStore{}
+		slog.Warn("STORAGE_MODE is not supported. Defaulting to 'memory'.", "storage_mode", storageMode)
+		storageMode = "memory"
+		store = NewInMemoryStore(getInitialCount())
+	}
+	// Startup errors from here on close the store before exiting.
+	if closer, ok := store.(io.Closer); ok {
+		fatalCloser = closer
+	}
+	if storageMode != "memory" && strings.TrimSpace(os.Getenv("INITIAL_COUNT")) != "" {
+		slog.Warn("INITIAL_COUNT is only supported in memory mode and is ignored.", "storage_mode", storageMode)
+	}
+
+	if seedURL := strings.TrimSpace(os.Getenv("SEED_URL")); seedURL != "" {
+		seedFromURL(store, seedURL, getSeedTimeout())
+	}
+
+	auditLog, err := NewAuditLogger(strings.TrimSpace(os.Getenv("AUDIT_LOG_PATH")))
+	if err != nil {
+		fatal("Failed to open audit log", "error", err)
+	}
+
+	dbRequestTimeout := getDBRequestTimeout(storageMode)
+	trustedProxyHops = getTrustedProxyHops()
+	exposeCountHeader = getEnvBool("EXPOSE_COUNT_HEADER", false)
+	if interval := getErrorLogInterval(); interval > 0 {
+		storeErrorLog = newErrorLogLimiter(interval)
 	}
+	clients := NewClientTracker(getClientTrackerSize(), getClientTrackerWindow())
+	flags := NewRuntimeFlags()
+	bucketGranularity := getBucketGranularity()
+	buckets := NewBucketRecorder(bucketGranularity, getBucketRetention(bucketGranularity))
 
 	router := mux.NewRouter()
-	router.HandleFunc("/health", HealthHandler)
-	router.Handle("/", CountHandler{store: store, dbRequestTimeout: getDBRequestTimeout()})
+	router.Use(requireHeader(requiredHeaderName, requiredHeaderValue))
+	watchdog := newWatchdogFromEnv(store)
+	readiness := storeReadiness(store, dbRequestTimeout)
+	router.HandleFunc("/readyz", readyzHandler(readiness, dbRequestTimeout)).Methods(http.MethodGet, http.MethodHead)
+	router.HandleFunc("/version", VersionHandler).Methods(http.MethodGet)
+	if getEnvBool("COMBINED_HEALTH", false) {
+		slog.Info("Combined health: /health also runs the /readyz check")
+		router.HandleFunc("/health", healthHandler(watchdog, readiness))
+	} else {
+		router.HandleFunc("/health", healthHandler(watchdog, nil))
+	}
+	if metricsExporter == metricsExporterPrometheus {
+		router.Handle("/metrics", promhttp.Handler())
+	}
+	displayFloor := getCountDisplayFloor()
+	router.HandleFunc("/buckets", BucketsHandler(buckets)).Methods(http.MethodGet)
+	router.HandleFunc("/badge.svg", BadgeHandler(store, dbRequestTimeout, displayFloor)).Methods(http.MethodGet, http.MethodHead)
+
+	readOnly := getEnvBool("READ_ONLY", false)
+	if readOnly {
+		slog.Info("Read-only mode: mutating routes are disabled")
+	}
+	mutating := rejectWhenReadOnly(readOnly)
+	// Admin routes have ADMIN_TOKEN, so AUTH_TOKEN guards the others.
+	authenticated := requireAuthToken(os.Getenv("AUTH_TOKEN"))
+	if os.Getenv("AUTH_TOKEN") != "" {
+		slog.Info("Requiring a bearer token on mutating routes")
+	}
+
+	router.HandleFunc("/generations", GenerationsHandler(store, dbRequestTimeout)).Methods(http.MethodGet)
+
+	if getEnvBool("ENABLE_ECHO", false) {
+		slog.Info("Echoing request metadata on /echo")
+		router.HandleFunc("/echo", EchoHandler(requiredHeaderName)).Methods(http.MethodGet)
+	}
+
+	watcher := NewCountWatcher()
+	admin := router.PathPrefix("/admin").Subrouter()
+	admin.Use(auditLog.Middleware, requireAdminToken(os.Getenv("ADMIN_TOKEN")))
+	admin.HandleFunc("/verify-schema", VerifySchemaHandler(store, dbRequestTimeout)).Methods(http.MethodPost)
+	admin.HandleFunc("/clients", ClientsHandler(clients)).Methods(http.MethodGet)
+	admin.HandleFunc("/flags", FlagsHandler(flags)).Methods(http.MethodGet, http.MethodPut)
+	admin.Handle("/rotate", mutating(RotateHandler(store, dbRequestTimeout, watcher))).Methods(http.MethodPost)
+
+	if getEnvBool("UNIQUE_COUNT_ENABLED", false) {
+		unique := UniqueHandler(NewHyperLogLog())
+		router.HandleFunc("/unique", unique).Methods(http.MethodGet)
+		router.Handle("/unique", authenticated(mutating(unique))).Methods(http.MethodPost)
+	}
+
+	countSeparator := getCountFormatSeparator()
+	signer := newCountSignerFromEnv()
+	shedder := newDBNodeShedderFromEnv(store)
+	readCount := ReadCountHandler(store, dbRequestTimeout, displayFloor, countSeparator, flags, signer, shedder)
+	router.HandleFunc("/count", readCount).Methods(http.MethodGet, http.MethodHead)
+	router.HandleFunc("/counter/{name}/count", readCount).Methods(http.MethodGet, http.MethodHead)
+
+	if peers := parsePeers(os.Getenv("PEERS")); len(peers) > 0 {
+		slog.Info("Comparing counts with peers on /cluster", "peers", len(peers))
+		router.HandleFunc("/cluster", ClusterHandler(store, dbRequestTimeout, peers, getPeerTimeout())).Methods(http.MethodGet)
+	}
+
+	poll := PollHandler(store, dbRequestTimeout, getPollTimeout(), getPollCheckInterval(), watcher, displayFloor, getPollMaxWaiters())
+	router.HandleFunc("/poll", poll).Methods(http.MethodGet)
+	router.HandleFunc("/counter/{name}/poll", poll).Methods(http.MethodGet)
+
+	countHandler := CountHandler{store: store, dbRequestTimeout: dbRequestTimeout, displayFloor: displayFloor, countSeparator: countSeparator, flags: flags, buckets: buckets, signer: signer, watcher: watcher, shedder: shedder, counterLimit: newRateLimiterFromEnv("PER_COUNTER_MAX_RPS", "PER_COUNTER_BURST", "counter")}
+	decrHandler := countHandler
+	decrHandler.weight = -1
+	queued := func(h http.Handler) http.Handler { return h }
+	if queue := newFairQueueFromEnv(); queue != nil {
+		queued = queue.Middleware
+	}
+	clientLimit := newRateLimiterFromEnv("RATE_LIMIT_RPS", "RATE_LIMIT_BURST", "client IP")
+	limited := func(h http.Handler) http.Handler { return h }
+	if clientLimit != nil {
+		limited = clientLimit.Middleware
+	}
+	resetEnabled := getEnvBool("RESET_ENABLED", false)
+	reset := auditLog.Middleware(authenticated(mutating(ResetHandler(store, dbRequestTimeout, displayFloor, resetEnabled, watcher))))
+	router.Handle("/reset", reset).Methods(http.MethodPost)
+	router.Handle("/counter/{name}/reset", reset).Methods(http.MethodPost)
+
+	for _, route := range weightedRoutes {
+		weighted := countHandler
+		weighted.counter, weighted.weight = route.Counter, route.Weight
+		router.Handle(route.Path, limited(authenticated(clients.Middleware(mutating(queued(weighted))))))
+		slog.Info("Weighted route", "path", route.Path, "weight", route.Weight, "counter", route.Counter)
+	}
+
+	router.Handle("/decr", limited(authenticated(clients.Middleware(mutating(queued(decrHandler))))))
+	router.Handle("/counter/{name}/decr", limited(authenticated(clients.Middleware(mutating(queued(decrHandler))))))
+	router.Handle("/counter/{name}", limited(authenticated(clients.Middleware(mutating(queued(countHandler))))))
+	router.Handle("/", limited(authenticated(clients.Middleware(mutating(queued(countHandler))))))
+
+	var dnsConn net.PacketConn
+	dnsDone := make(chan struct{})
+	if dnsServePort := strings.TrimSpace(os.Getenv("DNS_SERVE_PORT")); dnsServePort != "" {
+		dnsServeName := strings.TrimSpace(os.Getenv("DNS_SERVE_NAME"))
+		if dnsServeName == "" {
+			dnsServeName = defaultDNSServeName
+		}
+		var err error
+		dnsConn, err = net.ListenPacket("udp", fmt.Sprintf(":%s", dnsServePort))
+		if err != nil {
+			fatal("Failed to listen for DNS", "port", dnsServePort, "error", err)
+		}
+		dnsServer := NewDNSCountServer(store, dnsServeName, dbRequestTimeout)
+		slog.Info("Serving count as a DNS TXT record", "name", dnsServer.name, "udp_port", dnsServePort)
+		go func() {
+			defer close(dnsDone)
+			if err := dnsServer.Serve(dnsConn); err != nil {
+				slog.Error("DNS server stopped", "error", err)
+			}
+		}()
+	} else {
+		close(dnsDone)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if watchdog != nil {
+		go watchdog.Run(ctx)
+	}
+
+	if clientLimit != nil {
+		go clientLimit.RunPruner(ctx, defaultRateLimitPruneInterval)
+	}
+
+	if interval := getHeartbeatInterval(); interval > 0 {
+		slog.Info("Logging a heartbeat", "interval", interval)
+		go runHeartbeat(ctx, store, interval, dbRequestTimeout)
+	}
+
+	consumerDone := make(chan struct{})
+	if consumer := newStreamConsumerFromEnv(store, dbRequestTimeout, watcher, buckets); consumer != nil && !readOnly {
+		go func() {
+			defer close(consumerDone)
+			consumer.Run(ctx)
+		}()
+	} else {
+		if consumer != nil {
+			slog.Warn("REDIS_STREAM is ignored on READ_ONLY instances.")
+		}
+		close(consumerDone)
+	}
+
+	var pusher *MetricsPusher
+	if pushgatewayURL := strings.TrimSpace(os.Getenv("PUSHGATEWAY_URL")); pushgatewayURL != "" && metricsExporter != metricsExporterPrometheus {
+		slog.Warn("PUSHGATEWAY_URL is ignored with this METRICS_EXPORTER.", "exporter", metricsExporter)
+	} else if pushgatewayURL != "" {
+		job := getEnvOrDefault("PUSHGATEWAY_JOB", defaultPushgatewayJob)
+		instance, _ := os.Hostname()
+		interval := getPushgatewayInterval()
+		slog.Info("Pushing metrics", "url", pushgatewayURL, "interval", interval, "job", job, "instance", instance)
+		pusher = StartMetricsPusher(pushgatewayURL, job, instance, interval)
+	}
+
+	var remoteWriter *RemoteWriter
+	if remoteWriteURL := strings.TrimSpace(os.Getenv("REMOTE_WRITE_URL")); remoteWriteURL != "" && metricsExporter != metricsExporterPrometheus {
+		slog.Warn("REMOTE_WRITE_URL is ignored with this METRICS_EXPORTER.", "exporter", metricsExporter)
+	} else if remoteWriteURL != "" {
+		job := getEnvOrDefault("REMOTE_WRITE_JOB", defaultPushgatewayJob)
+		instance, _ := os.Hostname()
+		interval := getRemoteWriteInterval()
+		headers := getRemoteWriteHeaders()
+		slog.Info("Remote-writing metrics", "url", remoteWriteURL, "interval", interval, "job", job, "instance", instance, "extra_headers", len(headers))
+		remoteWriter = StartRemoteWriter(remoteWriteURL, headers, job, instance, interval)
+	}
 
 	// Serve!
-	fmt.Printf("Serving at http://localhost:%s\n", port)
-	log.Fatal(http.ListenAndServe(portWithColon, router))
+	shutdownTimeout := getShutdownTimeout()
+	corsOrigins := parseCORSOrigins(os.Getenv("CORS_ALLOW_ORIGIN"))
+	// exposeStorageMode wraps the whole handler rather than going through
+	// router.Use, which gorilla/mux skips for 404 and 405 responses.
+	handler := exposeStorageMode(getEnvBool("EXPOSE_STORAGE_MODE", false), storageMode)(cors(corsOrigins, router))
+	server := &http.Server{Addr: portWithColon, Handler: handler, TLSConfig: tlsConfig}
+	// A server error, such as the port being in use, goes through the same
+	// shutdown as a signal so the store's connections are closed before the
+	// process exits.
+	serveErr := make(chan error, 1)
+	go func() {
+		var err error
+		if certFile != "" {
+			slog.Info("Serving HTTPS", "url", "https://localhost:"+port)
+			err = server.ListenAndServeTLS(certFile, keyFile)
+		} else {
+			slog.Info("Serving HTTP", "url", "http://localhost:"+port)
+			err = server.ListenAndServe()
+		}
+		if !errors.Is(err, http.ErrServerClosed) {
+			serveErr <- err
+		}
+	}()
+
+	var serverFailed error
+	select {
+	case <-ctx.Done():
+	case serverFailed = <-serveErr:
+		slog.Error("Server failed; shutting down", "error", serverFailed)
+	}
+	stop()
+	if serverFailed == nil {
+		slog.Info("Shutting down: draining in-flight requests", "timeout", shutdownTimeout)
+		drainCtx, cancelDrain := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancelDrain()
+		if err := server.Shutdown(drainCtx); err != nil {
+			// Shutdown leaves connections that did not go idle open, so
+			// close them before the store goes away underneath them.
+			server.Close()
+			slog.Warn("Drain did not complete. Remaining connections were closed.", "error", err)
+		} else {
+			slog.Info("Drain complete: all in-flight requests finished")
+		}
+	}
+
+	// Stop taking DNS queries and let those in flight read the store before
+	// it closes.
+	if dnsConn != nil {
+		dnsConn.Close()
+	}
+	<-dnsDone
+
+	// Let an in-progress stream message finish before the store closes.
+	<-consumerDone
+
+	if pusher != nil {
+		pusher.Stop()
+	}
+	if remoteWriter != nil {
+		remoteWriter.Stop()
+	}
+
+	// Flush metrics recorded since the last export.
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownMetrics(shutdownCtx); err != nil {
+		slog.Error("Metrics shutdown failed", "error", err)
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		slog.Error("Tracing shutdown failed", "error", err)
+	}
+
+	if closer, ok := store.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			slog.Error("Closing store failed", "error", err)
+		}
+	}
+
+	if serverFailed != nil {
+		os.Exit(1)
+	}
+}
+
+// limitString prints a pool limit, where zero means unlimited.
+func limitString(n int) string {
+	if n == 0 {
+		return "unlimited"
+	}
+	return strconv.Itoa(n)
+}
+
+// redactURL returns raw with any password replaced by "xxxxx", for logging
+// connection URLs. A URL that does not parse is not echoed at all, since it
+// may still hold a secret.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "(unparseable URL)"
+	}
+	return u.Redacted()
 }
 
-// HealthHandler returns a succesful status and a message.
+func durationLimitString(d time.Duration) string {
+	if d == 0 {
+		return "unlimited"
+	}
+	return d.String()
+}
+
+func getEnvOrDefault(key, fallback string) string {
+	if value := strings.TrimSpace(os.Getenv(key)); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// HealthHandler returns a succesful status and a message. HEAD requests get
+// the same headers without a body.
 func HealthHandler(w http.ResponseWriter, r *http.Request) {
+	body := fmt.Sprintf("Hello, you've hit %s\n", r.URL.Path)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
 	w.WriteHeader(http.StatusOK)
-	fmt.Fprintf(w, "Hello, you've hit %s\n", r.URL.Path)
+	if r.Method == http.MethodHead {
+		return
+	}
+	_, _ = io.WriteString(w, body)
 }
 
 // Count stores a number that is being counted and other data to
 // return as JSON in the API.
 type Count struct {
-	Count    int64  `json:"count"`
-	Hostname string `json:"hostname"`
-	DBNode   string `json:"db_node,omitempty"`
-	Message  string `json:"message,omitempty"`
+	XMLName xml.Name `json:"-" xml:"Count"`
+	// Name is set on /counter/{name} routes only.
+	Name     string `json:"name,omitempty" xml:"name,omitempty"`
+	Count    int64  `json:"count" xml:"count"`
+	Hostname string `json:"hostname" xml:"hostname"`
+	DBNode   string `json:"db_node,omitempty" xml:"db_node,omitempty"`
+	Message  string `json:"message,omitempty" xml:"message,omitempty"`
+	// Delta is the change since the sequence passed as ?since=, and Stale
+	// reports that since was ahead of the counter (e.g. after a reset).
+	Delta *int64 `json:"delta,omitempty" xml:"delta,omitempty"`
+	Stale bool   `json:"stale,omitempty" xml:"stale,omitempty"`
+	// FormattedCount is Count with thousands separators, set only for
+	// ?format=locale.
+	FormattedCount string `json:"formatted_count,omitempty" xml:"formatted_count,omitempty"`
+	// DryRun marks a ?dry_run=true response: Count is what the next
+	// increment would return, and nothing was persisted.
+	DryRun bool `json:"dry_run,omitempty" xml:"dry_run,omitempty"`
+	// RequestID echoes the request's X-Request-ID, or the one generated for
+	// it, on the increment routes.
+	RequestID string `json:"request_id,omitempty" xml:"request_id,omitempty"`
+}
+
+// parseDryRun reads the optional ?dry_run= boolean.
+func parseDryRun(r *http.Request) (bool, error) {
+	raw := r.URL.Query().Get("dry_run")
+	if raw == "" {
+		return false, nil
+	}
+	dryRun, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, fmt.Errorf("invalid dry_run %q: must be a boolean", raw)
+	}
+	return dryRun, nil
+}
+
+// maxByBodyBytes bounds the JSON body read for "by".
+const maxByBodyBytes = 1 << 10
+
+// parseBy reads how many times to apply the route's step, for events that
+// stand for a batch: the optional ?by= parameter or, without it, a JSON body
+// like {"by": 5}. It defaults to 1 and must be a positive integer.
+func parseBy(w http.ResponseWriter, r *http.Request) (int64, error) {
+	raw := r.URL.Query().Get("by")
+	if raw == "" && r.Body != nil && strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		var body struct {
+			By json.Number `json:"by"`
+		}
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxByBodyBytes)).Decode(&body)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return 0, fmt.Errorf("invalid JSON body: %v", err)
+		}
+		raw = body.By.String()
+	}
+	if raw == "" {
+		return 1, nil
+	}
+	by, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || by <= 0 {
+		return 0, fmt.Errorf("invalid by %q: must be a positive integer", raw)
+	}
+	return by, nil
+}
+
+// parseFormat reads the optional ?format= parameter. "locale" is the only
+// supported value.
+func parseFormat(r *http.Request) (bool, error) {
+	switch format := r.URL.Query().Get("format"); format {
+	case "":
+		return false, nil
+	case "locale":
+		return true, nil
+	default:
+		return false, fmt.Errorf("unsupported format %q: only 'locale' is supported", format)
+	}
+}
+
+// formatCount renders n with sep between each group of three digits.
+func formatCount(n int64, sep string) string {
+	digits := strconv.FormatInt(n, 10)
+	sign := ""
+	if digits[0] == '-' {
+		sign, digits = "-", digits[1:]
+	}
+
+	var b strings.Builder
+	b.WriteString(sign)
+	for i, d := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteString(sep)
+		}
+		b.WriteRune(d)
+	}
+	return b.String()
+}
+
+// parseSince reads the optional ?since= sequence a client got from an
+// earlier response.
+func parseSince(r *http.Request) (since int64, ok bool, err error) {
+	raw := r.URL.Query().Get("since")
+	if raw == "" {
+		return 0, false, nil
+	}
+	since, err = strconv.ParseInt(raw, 10, 64)
+	if err != nil || since < 0 {
+		return 0, false, errors.New("since must be a non-negative integer")
+	}
+	return since, true, nil
+}
+
+// applySince fills in the delta between since and count.Count, the value
+// displayed after COUNT_DISPLAY_FLOOR, which is what clients pass back. A
+// since value ahead of it cannot come from this counter's history, so the
+// whole displayed value is reported as the delta and the response is marked
+// stale.
+func applySince(count *Count, since int64) {
+	current := count.Count
+	delta := current - since
+	if since > current {
+		delta = current
+		count.Stale = true
+	}
+	count.Delta = &delta
 }
 
 // CountHandler serves a JSON feed that contains a number that increments each time
@@ -269,34 +2361,242 @@ type Count struct {
 type CountHandler struct {
 	store            CounterStore
 	dbRequestTimeout time.Duration
+	displayFloor     int64
+	countSeparator   string
+	flags            *RuntimeFlags
+	buckets          *BucketRecorder
+	signer           *CountSigner
+	watcher          *CountWatcher
+	shedder          *DBNodeShedder
+	counterLimit     *RateLimiter
+	// counter, if set, is the counter every request applies to instead of
+	// the one addressed by the path.
+	counter string
+	// weight, if non-zero, is added on every request instead of the usual
+	// +1 (or -1 for DELETE).
+	weight int64
 }
 
 func (h CountHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// HEAD must not have side effects, so it never increments. The body
+	// length depends on the increment, so no Content-Length is sent.
+	if r.Method == http.MethodHead {
+		name, _, err := counterName(r)
+		if h.counter != "" {
+			name, err = h.counter, nil
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		headCount(w, r, h.store, h.dbRequestTimeout, h.displayFloor, name)
+		return
+	}
+
+	start := time.Now()
+	incrementsInFlight.Add(1)
+	defer func() {
+		incrementsInFlight.Add(-1)
+		incrementDuration.ObserveSince(start)
+	}()
+	defer h.shedder.Begin()()
+
+	r, span := startServerSpan(r, "CountHandler")
+	defer span.End()
+
 	hostname, _ := os.Hostname()
 
+	reqID := requestID(r)
+	w.Header().Set("X-Request-ID", reqID)
+	r = r.WithContext(withRequestID(r.Context(), reqID))
+
+	name, named, err := counterName(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Message: err.Error()})
+		return
+	}
+	if h.counter != "" {
+		name, named = h.counter, true
+	}
+	responseName := ""
+	if named {
+		responseName = name
+	}
+
+	since, hasSince, err := parseSince(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Message: err.Error()})
+		return
+	}
+
+	formatted, err := parseFormat(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Message: err.Error()})
+		return
+	}
+
+	dryRun, err := parseDryRun(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Message: err.Error()})
+		return
+	}
+
+	by, err := parseBy(w, r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Message: err.Error()})
+		return
+	}
+
+	if ok, wait := h.counterLimit.Allow(name, time.Now()); !ok {
+		counterRateLimitedTotal.Inc()
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(wait)))
+		writeJSON(w, http.StatusTooManyRequests, errorResponse{Message: fmt.Sprintf("counter %q is over its rate limit", name)})
+		return
+	}
+
+	if h.flags.MaintenanceMode.Load() {
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(h.dbRequestTimeout)))
+		writeCount(w, r, http.StatusServiceUnavailable, Count{
+			Name:      responseName,
+			Count:     -1,
+			Hostname:  hostname,
+			Message:   "Service is in maintenance mode",
+			RequestID: reqID,
+		})
+		return
+	}
+
 	ctx, cancel := context.WithTimeout(r.Context(), h.dbRequestTimeout)
 	defer cancel()
 
-	newCount, err := h.store.Incr(ctx)
+	ctx = withQueryTags(ctx, reqID, clientIP(r))
+
+	step := h.weight
+	if step == 0 {
+		step = 1
+		if r.Method == http.MethodDelete {
+			step = -1
+		}
+	}
+	magnitude := step
+	if magnitude < 0 {
+		magnitude = -magnitude
+	}
+	if by > math.MaxInt64/magnitude {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Message: fmt.Sprintf("by %d is too large", by)})
+		return
+	}
+	step *= by
+
+	span.SetAttributes(
+		attribute.String("counter.name", name),
+		attribute.Int64("counter.step", step),
+		attribute.Bool("counter.dry_run", dryRun),
+	)
+
+	var newCount int64
+	storeStart := time.Now()
+	if dryRun {
+		// A dry run reads without writing, so concurrent increments may
+		// claim the predicted value first.
+		storeCtx, storeSpan := tracer.Start(ctx, "store.GetCount")
+		newCount, err = h.store.GetCount(storeCtx, name)
+		endSpanWithErr(storeSpan, err)
+		newCount += step
+	} else {
+		storeCtx, storeSpan := tracer.Start(ctx, "store.IncrBy")
+		newCount, err = h.store.IncrBy(storeCtx, name, step)
+		endSpanWithErr(storeSpan, err)
+	}
+	// Dry runs are reads, which would pull the write latency down.
+	if !dryRun {
+		outcome := "ok"
+		if err != nil {
+			outcome = "error"
+		}
+		storeRequestDuration.ObserveSince(storeStart, outcome)
+	}
 	if err != nil {
 		count := Count{
-			Count:    -1,
-			Hostname: hostname,
-			Message:  fmt.Sprintf("DB Error: %v", err),
+			Name:      responseName,
+			Count:     -1,
+			Hostname:  hostname,
+			Message:   fmt.Sprintf("DB Error: %v", err),
+			RequestID: reqID,
 		}
-		writeJSON(w, count)
+		incrementErrorsTotal.Inc()
+		span.SetStatus(codes.Error, "store request failed")
+		logStoreError(r.Context(), "Store request failed", err, "counter", name, "step", step)
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(h.dbRequestTimeout)))
+		writeCount(w, r, http.StatusServiceUnavailable, count)
 		return
 	}
 
+	// Each increment is a single-row UPDATE ... RETURNING (or a mutex-guarded
+	// add in memory), so while the count only grows the returned value is
+	// unique and increasing and doubles as an event sequence number. That
+	// stops holding once a decrement, a negative weighted route or a reset
+	// moves the count back: later increments hand out the same values again.
+	// A dry run claimed nothing, and a decrement moves backwards, so neither
+	// gets a sequence.
+	switch {
+	case dryRun:
+	case step < 0:
+		if name == defaultCounterName {
+			currentCount.Set(float64(newCount))
+		}
+		decrementsTotal.Inc()
+	default:
+		w.Header().Set("X-Count-Sequence", strconv.FormatInt(newCount, 10))
+		if name == defaultCounterName {
+			currentCount.Set(float64(newCount))
+		}
+		incrementsTotal.Inc()
+		h.buckets.Record(time.Now())
+	}
+	if !dryRun {
+		h.watcher.Notify(name)
+	}
+
 	count := Count{
-		Count:    newCount,
-		Hostname: hostname,
+		Name:      responseName,
+		Count:     displayCount(newCount, h.displayFloor),
+		Hostname:  hostname,
+		DryRun:    dryRun,
+		RequestID: reqID,
+	}
+	if hasSince {
+		applySince(&count, since)
+	}
+	if formatted {
+		count.FormattedCount = formatCount(count.Count, h.countSeparator)
+	}
+
+	span.SetAttributes(attribute.Int64("counter.count", newCount))
+
+	if h.flags.DBNodeLookup.Load() && h.shedder.Allow() {
+		nodeCtx, nodeSpan := tracer.Start(ctx, "store.GetDBNode")
+		dbNode, dbErr := h.store.GetDBNode(nodeCtx)
+		if dbErr == nil && dbNode != "" {
+			count.DBNode = dbNode
+			nodeSpan.SetAttributes(attribute.String("db.node", dbNode))
+			span.SetAttributes(attribute.String("db.node", dbNode))
+		}
+		endSpanWithErr(nodeSpan, dbErr)
+	}
+
+	// VERBOSE_LOGGING raises the per-request line to info so it shows
+	// without LOG_LEVEL=debug.
+	level := slog.LevelDebug
+	if h.flags.VerboseLogging.Load() {
+		level = slog.LevelInfo
 	}
+	slog.Log(r.Context(), level, "Counter updated", "counter", name, "step", step, "count", newCount, "dry_run", dryRun, "db_node", count.DBNode, "client", clientIP(r))
 
-	dbNode, dbErr := h.store.GetDBNode(ctx)
-	if dbErr == nil {
-		count.DBNode = dbNode
+	// A dry run's count is only a prediction, so it is never vouched for.
+	if !dryRun {
+		h.signer.setHeader(w, name, count.Count)
 	}
 
-	writeJSON(w, count)
+	writeCount(w, r, http.StatusOK, count)
 }
@@ -0,0 +1,312 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+const (
+	metricsExporterPrometheus = "prometheus"
+	metricsExporterOTLP       = "otlp"
+	metricsExporterNone       = "none"
+)
+
+// instrument is one metric that can be backed by Prometheus or OpenTelemetry.
+// Call sites record through the wrapper types below, so switching
+// METRICS_EXPORTER never touches them. Until setupMetrics binds a backend,
+// recording is a no-op.
+type instrument interface {
+	registerPrometheus(prometheus.Registerer)
+	registerOTel(metric.Meter) error
+}
+
+type counterMetric struct {
+	name, help string
+	labels     []string
+	prom       *prometheus.CounterVec
+	otel       metric.Int64Counter
+}
+
+func newCounter(name, help string, labels ...string) *counterMetric {
+	return &counterMetric{name: name, help: help, labels: labels}
+}
+
+func (m *counterMetric) registerPrometheus(reg prometheus.Registerer) {
+	m.prom = prometheus.NewCounterVec(prometheus.CounterOpts{Name: m.name, Help: m.help}, m.labels)
+	reg.MustRegister(m.prom)
+}
+
+func (m *counterMetric) registerOTel(meter metric.Meter) (err error) {
+	m.otel, err = meter.Int64Counter(m.name, metric.WithDescription(m.help))
+	return err
+}
+
+// Inc adds one, with labelValues matching the labels the metric was declared
+// with.
+func (m *counterMetric) Inc(labelValues ...string) {
+	if m.prom != nil {
+		m.prom.WithLabelValues(labelValues...).Inc()
+	}
+	if m.otel != nil {
+		m.otel.Add(context.Background(), 1, metric.WithAttributes(otelAttributes(m.labels, labelValues)...))
+	}
+}
+
+type gaugeMetric struct {
+	name, help string
+	prom       prometheus.Gauge
+	otel       metric.Float64Gauge
+}
+
+func newGauge(name, help string) *gaugeMetric {
+	return &gaugeMetric{name: name, help: help}
+}
+
+func (m *gaugeMetric) registerPrometheus(reg prometheus.Registerer) {
+	m.prom = prometheus.NewGauge(prometheus.GaugeOpts{Name: m.name, Help: m.help})
+	reg.MustRegister(m.prom)
+}
+
+func (m *gaugeMetric) registerOTel(meter metric.Meter) (err error) {
+	m.otel, err = meter.Float64Gauge(m.name, metric.WithDescription(m.help))
+	return err
+}
+
+func (m *gaugeMetric) Set(v float64) {
+	if m.prom != nil {
+		m.prom.Set(v)
+	}
+	if m.otel != nil {
+		m.otel.Record(context.Background(), v)
+	}
+}
+
+// upDownMetric is a gauge that is only ever moved relative to its current
+// value, such as an in-flight count.
+type upDownMetric struct {
+	name, help string
+	prom       prometheus.Gauge
+	otel       metric.Int64UpDownCounter
+}
+
+func newUpDown(name, help string) *upDownMetric {
+	return &upDownMetric{name: name, help: help}
+}
+
+func (m *upDownMetric) registerPrometheus(reg prometheus.Registerer) {
+	m.prom = prometheus.NewGauge(prometheus.GaugeOpts{Name: m.name, Help: m.help})
+	reg.MustRegister(m.prom)
+}
+
+func (m *upDownMetric) registerOTel(meter metric.Meter) (err error) {
+	m.otel, err = meter.Int64UpDownCounter(m.name, metric.WithDescription(m.help))
+	return err
+}
+
+func (m *upDownMetric) Add(delta int64) {
+	if m.prom != nil {
+		m.prom.Add(float64(delta))
+	}
+	if m.otel != nil {
+		m.otel.Add(context.Background(), delta)
+	}
+}
+
+type histogramMetric struct {
+	name, help string
+	labels     []string
+	prom       *prometheus.HistogramVec
+	otel       metric.Float64Histogram
+}
+
+func newHistogram(name, help string, labels ...string) *histogramMetric {
+	return &histogramMetric{name: name, help: help, labels: labels}
+}
+
+func (m *histogramMetric) registerPrometheus(reg prometheus.Registerer) {
+	m.prom = prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: m.name, Help: m.help, Buckets: prometheus.DefBuckets}, m.labels)
+	reg.MustRegister(m.prom)
+}
+
+func (m *histogramMetric) registerOTel(meter metric.Meter) (err error) {
+	m.otel, err = meter.Float64Histogram(m.name, metric.WithDescription(m.help), metric.WithUnit("s"))
+	return err
+}
+
+// ObserveSince records the time elapsed since start, in seconds.
+func (m *histogramMetric) ObserveSince(start time.Time, labelValues ...string) {
+	seconds := time.Since(start).Seconds()
+	if m.prom != nil {
+		m.prom.WithLabelValues(labelValues...).Observe(seconds)
+	}
+	if m.otel != nil {
+		m.otel.Record(context.Background(), seconds, metric.WithAttributes(otelAttributes(m.labels, labelValues)...))
+	}
+}
+
+func otelAttributes(labels, values []string) []attribute.KeyValue {
+	attrs := make([]attribute.KeyValue, 0, len(labels))
+	for i, label := range labels {
+		if i < len(values) {
+			attrs = append(attrs, attribute.String(label, values[i]))
+		}
+	}
+	return attrs
+}
+
+var (
+	dbConnAcquireTimeoutsTotal = newCounter(
+		"counting_db_conn_acquire_timeouts_total",
+		"DB connection checkouts that waited on a full pool for longer than DB_CONN_ACQUIRE_TIMEOUT_MS, by reason (pool_exhausted).",
+		"reason")
+
+	dbQueryTimeoutsTotal = newCounter(
+		"counting_db_query_timeouts_total",
+		"DB statements that ran out of request time after a connection was acquired.")
+
+	dbCheckoutValidationFailuresTotal = newCounter(
+		"counting_db_checkout_validation_failures_total",
+		"Checked-out DB connections discarded because they failed the DB_VALIDATE_ON_CHECKOUT ping.")
+
+	dbWriteRetriesTotal = newCounter(
+		"counting_db_write_retries_total",
+		"DB writes retried after a failure that left the count untouched.")
+
+	currentCount = newGauge(
+		"counting_current_count",
+		"Most recent counter value returned by an increment.")
+
+	incrementsTotal = newCounter(
+		"counting_increments_total",
+		"Successful increments.")
+
+	decrementsTotal = newCounter(
+		"counting_decrements_total",
+		"Successful decrements.")
+
+	incrementErrorsTotal = newCounter(
+		"counting_increment_errors_total",
+		"Increments and decrements that failed with a store error.")
+
+	incrementDuration = newHistogram(
+		"counting_increment_duration_seconds",
+		"Latency of requests to the increment route.")
+
+	storeRequestDuration = newHistogram(
+		"counting_store_request_duration_seconds",
+		"Latency of the store call made by the increment route, including connection checkout, by outcome (ok or error). Dry runs are not recorded.",
+		"outcome")
+
+	dbStatementDuration = newHistogram(
+		"counting_db_statement_duration_seconds",
+		"Latency of DB operations by operation (incr, decr, get_count, get_db_node, ensure_schema, seed_row, verify_schema), when DB_STATEMENT_METRICS is enabled.",
+		"operation")
+
+	incrementsInFlight = newUpDown(
+		"counting_increments_in_flight",
+		"Requests to the increment route currently being served.")
+
+	checksumMismatchesTotal = newCounter(
+		"counting_checksum_mismatches_total",
+		"Reads of a counters row whose COUNT_HMAC_KEY checksum did not match.")
+
+	fairQueueRejectionsTotal = newCounter(
+		"counting_fair_queue_rejections_total",
+		"Requests rejected by the fair queue, by reason (full or timeout).",
+		"reason")
+
+	counterRateLimitedTotal = newCounter(
+		"counting_counter_rate_limited_total",
+		"Requests rejected with 429 because their counter exceeded PER_COUNTER_MAX_RPS.")
+
+	clientRateLimitedTotal = newCounter(
+		"counting_client_rate_limited_total",
+		"Requests rejected with 429 because their client IP exceeded RATE_LIMIT_RPS.")
+
+	pollRejectedTotal = newCounter(
+		"counting_poll_rejected_total",
+		"Long polls rejected with 503 because POLL_MAX_WAITERS were already waiting.")
+
+	responseWriteErrorsTotal = newCounter(
+		"counting_response_write_errors_total",
+		"Responses that failed to encode or write, usually because the client disconnected, by format (json, protobuf or xml).",
+		"format")
+)
+
+var instruments = []instrument{
+	dbConnAcquireTimeoutsTotal,
+	dbQueryTimeoutsTotal,
+	dbCheckoutValidationFailuresTotal,
+	dbWriteRetriesTotal,
+	currentCount,
+	incrementsTotal,
+	decrementsTotal,
+	incrementErrorsTotal,
+	incrementDuration,
+	storeRequestDuration,
+	dbStatementDuration,
+	incrementsInFlight,
+	checksumMismatchesTotal,
+	fairQueueRejectionsTotal,
+	counterRateLimitedTotal,
+	clientRateLimitedTotal,
+	pollRejectedTotal,
+	responseWriteErrorsTotal,
+}
+
+// setupMetrics binds every instrument to the selected exporter. For OTLP the
+// exporter is configured by the standard OTEL_EXPORTER_OTLP_* variables, and
+// the returned shutdown flushes pending data. Prometheus metrics are served
+// from /metrics by the caller.
+func setupMetrics(ctx context.Context, exporter string) (shutdown func(context.Context) error, err error) {
+	noop := func(context.Context) error { return nil }
+
+	switch exporter {
+	case metricsExporterPrometheus:
+		for _, inst := range instruments {
+			inst.registerPrometheus(prometheus.DefaultRegisterer)
+		}
+		return noop, nil
+	case metricsExporterOTLP:
+		otlpExporter, err := otlpmetrichttp.New(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("creating OTLP metrics exporter: %w", err)
+		}
+		// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the
+		// default service name.
+		res, err := resource.New(ctx,
+			resource.WithAttributes(attribute.String("service.name", "counting-service")),
+			resource.WithFromEnv(),
+			resource.WithTelemetrySDK(),
+		)
+		if err != nil {
+			return nil, err
+		}
+		provider := sdkmetric.NewMeterProvider(
+			sdkmetric.WithReader(sdkmetric.NewPeriodicReader(otlpExporter)),
+			sdkmetric.WithResource(res),
+		)
+		meter := provider.Meter("counting-service")
+		for _, inst := range instruments {
+			if err := inst.registerOTel(meter); err != nil {
+				return nil, err
+			}
+		}
+		return provider.Shutdown, nil
+	case metricsExporterNone:
+		return noop, nil
+	default:
+		return nil, fmt.Errorf("unsupported METRICS_EXPORTER %q", exporter)
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"net/http"
+)
+
+// requireHeader rejects requests whose header name does not carry value with
+// 403. It is a coarse shared-secret gate for internal deployments, so health
+// and readiness probes are exempt. When name is empty the middleware is a no-op.
+func requireHeader(name, value string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if name == "" {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/health" || r.URL.Path == "/readyz" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if got, ok := r.Header[http.CanonicalHeaderKey(name)]; !ok || len(got) != 1 || !tokenMatches(got[0], value) {
+				writeJSON(w, http.StatusForbidden, errorResponse{Message: "missing or invalid " + name + " header"})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requireAuthToken rejects requests to mutating routes with 401 unless they
+// carry "Authorization: Bearer <token>". HEAD requests have no side effects
+// and pass through, as they do on READ_ONLY instances. When token is empty
+// the middleware is a no-op.
+func requireAuthToken(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if token == "" {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodHead && !tokenMatches(bearerToken(r), token) {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="counting"`)
+				writeJSON(w, http.StatusUnauthorized, errorResponse{Message: "missing or invalid bearer token"})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// rejectWhenReadOnly answers requests to mutating routes with 405 when the
+// service runs with READ_ONLY=true, guaranteeing it never writes the
+// counter. HEAD requests have no side effects and pass through.
+func rejectWhenReadOnly(readOnly bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if !readOnly {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("Allow", http.MethodHead)
+			writeJSON(w, http.StatusMethodNotAllowed, errorResponse{Message: "this instance is read-only (READ_ONLY=true)"})
+		})
+	}
+}
+
+// exposeStorageMode sets X-Storage-Mode on every response, so callers can
+// tell which backend an instance runs on. It is a no-op when disabled.
+func exposeStorageMode(enabled bool, mode string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if !enabled {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Storage-Mode", mode)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
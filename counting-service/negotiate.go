@@ -0,0 +1,135 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+
+	countpb "github.com/hashicorp/demo-consul-101/services/counting-service/proto"
+)
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative proto/count.proto
+
+const (
+	contentTypeJSON     = "application/json"
+	contentTypeProtobuf = "application/x-protobuf"
+	contentTypeXML      = "application/xml"
+)
+
+// countContentTypes lists the representations a Count can be served in, in
+// order of preference when a client accepts several equally.
+var countContentTypes = []string{contentTypeJSON, contentTypeProtobuf, contentTypeXML}
+
+// contentTypeAliases maps alternative media types to the one served.
+var contentTypeAliases = map[string]string{
+	"text/xml": contentTypeXML,
+}
+
+// negotiateContentType picks the best supported media type for the request's
+// Accept header, defaulting to JSON when the header is absent or nothing
+// supported is acceptable.
+func negotiateContentType(r *http.Request) string {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return contentTypeJSON
+	}
+
+	best, bestQ := contentTypeJSON, 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, _ := strings.Cut(part, ";")
+		mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+		if alias, ok := contentTypeAliases[mediaType]; ok {
+			mediaType = alias
+		}
+
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if ok && strings.TrimSpace(key) == "q" {
+				if parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		if q <= bestQ {
+			continue
+		}
+
+		for _, supported := range countContentTypes {
+			if mediaType == supported || mediaType == "*/*" || mediaType == "application/*" {
+				best, bestQ = supported, q
+				break
+			}
+		}
+	}
+	return best
+}
+
+// exposeCountHeader, from EXPOSE_COUNT_HEADER, repeats a successful
+// response's count in an X-Count header, so clients can skip the body.
+var exposeCountHeader bool
+
+// writeCount writes a Count in the representation negotiated from the
+// request's Accept header.
+func writeCount(w http.ResponseWriter, r *http.Request, status int, payload Count) {
+	w.Header().Add("Vary", "Accept")
+	if exposeCountHeader && payload.Message == "" {
+		w.Header().Set("X-Count", strconv.FormatInt(payload.Count, 10))
+	}
+	switch negotiateContentType(r) {
+	case contentTypeProtobuf:
+		writeProto(w, status, payload)
+	case contentTypeXML:
+		writeXML(w, status, payload)
+	default:
+		writeJSON(w, status, payload)
+	}
+}
+
+// writeProto writes payload as a protobuf-encoded Count message, defined in
+// proto/count.proto.
+func writeProto(w http.ResponseWriter, status int, payload Count) {
+	b, err := proto.Marshal(&countpb.Count{
+		Count:          payload.Count,
+		Hostname:       payload.Hostname,
+		DbNode:         payload.DBNode,
+		Message:        payload.Message,
+		Delta:          payload.Delta,
+		Stale:          payload.Stale,
+		FormattedCount: payload.FormattedCount,
+		DryRun:         payload.DryRun,
+		Name:           payload.Name,
+		RequestId:      payload.RequestID,
+	})
+	if err != nil {
+		// Only invalid UTF-8 in a string field, such as a counter name,
+		// fails to encode.
+		noteWriteErr(w, "protobuf", err)
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Message: "count cannot be encoded as protobuf"})
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypeProtobuf)
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	w.WriteHeader(status)
+	_, err = w.Write(b)
+	noteWriteErr(w, "protobuf", err)
+}
+
+// writeXML writes payload as an XML document, mirroring writeJSON.
+func writeXML(w http.ResponseWriter, status int, payload any) {
+	w.Header().Set("Content-Type", contentTypeXML+"; charset=utf-8")
+	w.WriteHeader(status)
+	_, err := io.WriteString(w, xml.Header)
+	if err == nil {
+		err = xml.NewEncoder(w).Encode(payload)
+	}
+	noteWriteErr(w, "xml", err)
+}
@@ -0,0 +1,120 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+
+	countpb "github.com/hashicorp/demo-consul-101/services/counting-service/proto"
+)
+
+func TestWriteCountXMLRoundTrip(t *testing.T) {
+	delta := int64(-3)
+	counts := []Count{
+		{Count: 42, Hostname: "counting-1"},
+		{
+			Name:           "campaign <a&b>",
+			Count:          1234567,
+			Hostname:       "counting-2",
+			DBNode:         "Node 3",
+			Delta:          &delta,
+			Stale:          true,
+			FormattedCount: "1,234,567",
+			DryRun:         true,
+			RequestID:      "8d6f9a1e-2b1c-4a55-9f4e-2a0d1c3b7e10",
+		},
+		{Count: -1, Hostname: "counting-3", Message: "DB Error: timeout"},
+	}
+	for _, accept := range []string{"application/xml", "text/xml"} {
+		for _, want := range counts {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept", accept)
+			rec := httptest.NewRecorder()
+			writeCount(rec, req, http.StatusOK, want)
+
+			if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, contentTypeXML) {
+				t.Fatalf("Accept %s: Content-Type = %q, want %s", accept, ct, contentTypeXML)
+			}
+			body := rec.Body.String()
+			if !strings.HasPrefix(body, xml.Header) {
+				t.Errorf("Accept %s: body does not start with the XML declaration: %q", accept, body)
+			}
+
+			var got Count
+			if err := xml.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatalf("Accept %s: decoding %q: %v", accept, body, err)
+			}
+			got.XMLName, want.XMLName = xml.Name{}, xml.Name{}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Accept %s: round trip = %+v, want %+v", accept, got, want)
+			}
+		}
+	}
+}
+
+func TestWriteCountProtobufRoundTrip(t *testing.T) {
+	delta := int64(-3)
+	counts := []Count{
+		{Count: 42, Hostname: "counting-1"},
+		{
+			Name:           "campaign",
+			Count:          1234567,
+			Hostname:       "counting-2",
+			DBNode:         "3",
+			Delta:          &delta,
+			Stale:          true,
+			FormattedCount: "1,234,567",
+			DryRun:         true,
+			RequestID:      "8d6f9a1e-2b1c-4a55-9f4e-2a0d1c3b7e10",
+		},
+		{Count: -1, Hostname: "counting-3", Message: "DB Error: timeout"},
+	}
+	for _, want := range counts {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept", contentTypeProtobuf)
+		rec := httptest.NewRecorder()
+		writeCount(rec, req, http.StatusOK, want)
+
+		if ct := rec.Header().Get("Content-Type"); ct != contentTypeProtobuf {
+			t.Fatalf("Content-Type = %q, want %s", ct, contentTypeProtobuf)
+		}
+		var msg countpb.Count
+		if err := proto.Unmarshal(rec.Body.Bytes(), &msg); err != nil {
+			t.Fatalf("decoding %x: %v", rec.Body.Bytes(), err)
+		}
+		got := Count{
+			Name:           msg.GetName(),
+			Count:          msg.GetCount(),
+			Hostname:       msg.GetHostname(),
+			DBNode:         msg.GetDbNode(),
+			Message:        msg.GetMessage(),
+			Delta:          msg.Delta,
+			Stale:          msg.GetStale(),
+			FormattedCount: msg.GetFormattedCount(),
+			DryRun:         msg.GetDryRun(),
+			RequestID:      msg.GetRequestId(),
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("round trip = %+v, want %+v", got, want)
+		}
+	}
+}
+
+func TestWriteCountProtobufInvalidUTF8(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept", contentTypeProtobuf)
+	rec := httptest.NewRecorder()
+	writeCount(rec, req, http.StatusOK, Count{Name: "bad\xff", Count: 1})
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultPollTimeout = 30 * time.Second
+const defaultPollCheckInterval = 1 * time.Second
+const defaultPollMaxWaiters = 1000
+
+// CountWatcher wakes long-poll requests as soon as this instance changes the
+// counter they wait on. Changes made elsewhere, such as by another replica
+// sharing the database, are only seen when a poll re-reads the store.
+type CountWatcher struct {
+	mu       sync.Mutex
+	counters map[string]*counterWatch
+}
+
+// counterWatch is the channel pollers of one counter wait on, kept only while
+// some poller holds it.
+type counterWatch struct {
+	changed chan struct{}
+	holders int
+}
+
+func NewCountWatcher() *CountWatcher {
+	return &CountWatcher{counters: make(map[string]*counterWatch)}
+}
+
+// Changed returns a channel that is closed on the next Notify of name, and a
+// release func to call once the caller stops waiting on it.
+func (cw *CountWatcher) Changed(name string) (<-chan struct{}, func()) {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	watch, ok := cw.counters[name]
+	if !ok {
+		watch = &counterWatch{changed: make(chan struct{})}
+		cw.counters[name] = watch
+	}
+	watch.holders++
+	return watch.changed, func() {
+		cw.mu.Lock()
+		defer cw.mu.Unlock()
+		watch.holders--
+		if watch.holders == 0 && cw.counters[name] == watch {
+			delete(cw.counters, name)
+		}
+	}
+}
+
+// Notify wakes everything waiting on Changed for name. A nil watcher does
+// nothing.
+func (cw *CountWatcher) Notify(name string) {
+	if cw == nil {
+		return
+	}
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	if watch, ok := cw.counters[name]; ok {
+		close(watch.changed)
+		delete(cw.counters, name)
+	}
+}
+
+// PollHandler long-polls a counter: with ?since=, it waits until the count is
+// no longer since, or pollTimeout passes, and then answers with the current
+// count and delta. Without since it answers at once. The store is re-read on
+// every local change to the counter and every checkInterval. Once maxWaiters
+// polls are waiting, further ones are refused with a 503; zero means no limit.
+func PollHandler(store CounterStore, timeout, pollTimeout, checkInterval time.Duration, watcher *CountWatcher, displayFloor int64, maxWaiters int64) http.HandlerFunc {
+	var waiters atomic.Int64
+	return func(w http.ResponseWriter, r *http.Request) {
+		hostname, _ := os.Hostname()
+
+		name, named, err := counterName(r)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Message: err.Error()})
+			return
+		}
+		responseName := ""
+		if named {
+			responseName = name
+		}
+
+		since, hasSince, err := parseSince(r)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Message: err.Error()})
+			return
+		}
+
+		if hasSince {
+			if n := waiters.Add(1); maxWaiters > 0 && n > maxWaiters {
+				waiters.Add(-1)
+				pollRejectedTotal.Inc()
+				w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(checkInterval)))
+				writeJSON(w, http.StatusServiceUnavailable, errorResponse{Message: fmt.Sprintf("too many waiting polls (limit %d)", maxWaiters)})
+				return
+			}
+			defer waiters.Add(-1)
+		}
+
+		deadline := time.NewTimer(pollTimeout)
+		defer deadline.Stop()
+		ticker := time.NewTicker(checkInterval)
+		defer ticker.Stop()
+
+		for {
+			// Take the channel before reading, so a change between the read
+			// and the wait still wakes us.
+			changed, release := watcher.Changed(name)
+
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			current, err := store.GetCount(ctx, name)
+			cancel()
+			if err != nil {
+				release()
+				if r.Context().Err() != nil {
+					return
+				}
+				w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(timeout)))
+				writeCount(w, r, http.StatusServiceUnavailable, Count{
+					Name:     responseName,
+					Count:    -1,
+					Hostname: hostname,
+					Message:  fmt.Sprintf("DB Error: %v", err),
+				})
+				return
+			}
+
+			timedOut := false
+			// Clients pass back the displayed count, so compare that: below
+			// COUNT_DISPLAY_FLOOR the raw value never equals it.
+			if hasSince && displayCount(current, displayFloor) == since {
+				recheck := false
+				select {
+				case <-r.Context().Done():
+					release()
+					return
+				case <-changed:
+					recheck = true
+				case <-ticker.C:
+					recheck = true
+				case <-deadline.C:
+					timedOut = true
+				}
+				if recheck {
+					release()
+					continue
+				}
+			}
+			release()
+
+			count := Count{
+				Name:     responseName,
+				Count:    displayCount(current, displayFloor),
+				Hostname: hostname,
+			}
+			if hasSince {
+				applySince(&count, since)
+			}
+			if timedOut {
+				w.Header().Set("X-Poll-Timeout", "true")
+			}
+			writeCount(w, r, http.StatusOK, count)
+			return
+		}
+	}
+}
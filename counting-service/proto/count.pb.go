@@ -0,0 +1,218 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.8
+// 	protoc        (unknown)
+// source: proto/count.proto
+
+package countpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Count is returned by the counting service when a client sends
+// "Accept: application/x-protobuf". It mirrors the JSON Count response.
+type Count struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	Count    int64                  `protobuf:"varint,1,opt,name=count,proto3" json:"count,omitempty"`
+	Hostname string                 `protobuf:"bytes,2,opt,name=hostname,proto3" json:"hostname,omitempty"`
+	DbNode   string                 `protobuf:"bytes,3,opt,name=db_node,json=dbNode,proto3" json:"db_node,omitempty"`
+	Message  string                 `protobuf:"bytes,4,opt,name=message,proto3" json:"message,omitempty"`
+	// Set when the request passed ?since=<sequence>.
+	Delta *int64 `protobuf:"varint,5,opt,name=delta,proto3,oneof" json:"delta,omitempty"`
+	Stale bool   `protobuf:"varint,6,opt,name=stale,proto3" json:"stale,omitempty"`
+	// Set when the request passed ?format=locale.
+	FormattedCount string `protobuf:"bytes,7,opt,name=formatted_count,json=formattedCount,proto3" json:"formatted_count,omitempty"`
+	// Set when the request passed ?dry_run=true.
+	DryRun bool `protobuf:"varint,8,opt,name=dry_run,json=dryRun,proto3" json:"dry_run,omitempty"`
+	// Set on /counter/{name} routes.
+	Name string `protobuf:"bytes,9,opt,name=name,proto3" json:"name,omitempty"`
+	// Set on the increment routes: the request's X-Request-ID, or a
+	// generated UUID.
+	RequestId     string `protobuf:"bytes,10,opt,name=request_id,json=requestId,proto3" json:"request_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Count) Reset() {
+	*x = Count{}
+	mi := &file_proto_count_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Count) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Count) ProtoMessage() {}
+
+func (x *Count) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_count_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Count.ProtoReflect.Descriptor instead.
+func (*Count) Descriptor() ([]byte, []int) {
+	return file_proto_count_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Count) GetCount() int64 {
+	if x != nil {
+		return x.Count
+	}
+	return 0
+}
+
+func (x *Count) GetHostname() string {
+	if x != nil {
+		return x.Hostname
+	}
+	return ""
+}
+
+func (x *Count) GetDbNode() string {
+	if x != nil {
+		return x.DbNode
+	}
+	return ""
+}
+
+func (x *Count) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *Count) GetDelta() int64 {
+	if x != nil && x.Delta != nil {
+		return *x.Delta
+	}
+	return 0
+}
+
+func (x *Count) GetStale() bool {
+	if x != nil {
+		return x.Stale
+	}
+	return false
+}
+
+func (x *Count) GetFormattedCount() string {
+	if x != nil {
+		return x.FormattedCount
+	}
+	return ""
+}
+
+func (x *Count) GetDryRun() bool {
+	if x != nil {
+		return x.DryRun
+	}
+	return false
+}
+
+func (x *Count) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Count) GetRequestId() string {
+	if x != nil {
+		return x.RequestId
+	}
+	return ""
+}
+
+var File_proto_count_proto protoreflect.FileDescriptor
+
+const file_proto_count_proto_rawDesc = "" +
+	"\n" +
+	"\x11proto/count.proto\x12\bcounting\"\x9c\x02\n" +
+	"\x05Count\x12\x14\n" +
+	"\x05count\x18\x01 \x01(\x03R\x05count\x12\x1a\n" +
+	"\bhostname\x18\x02 \x01(\tR\bhostname\x12\x17\n" +
+	"\adb_node\x18\x03 \x01(\tR\x06dbNode\x12\x18\n" +
+	"\amessage\x18\x04 \x01(\tR\amessage\x12\x19\n" +
+	"\x05delta\x18\x05 \x01(\x03H\x00R\x05delta\x88\x01\x01\x12\x14\n" +
+	"\x05stale\x18\x06 \x01(\bR\x05stale\x12'\n" +
+	"\x0fformatted_count\x18\a \x01(\tR\x0eformattedCount\x12\x17\n" +
+	"\adry_run\x18\b \x01(\bR\x06dryRun\x12\x12\n" +
+	"\x04name\x18\t \x01(\tR\x04name\x12\x1d\n" +
+	"\n" +
+	"request_id\x18\n" +
+	" \x01(\tR\trequestIdB\b\n" +
+	"\x06_deltaBNZLgithub.com/hashicorp/demo-consul-101/services/counting-service/proto;countpbb\x06proto3"
+
+var (
+	file_proto_count_proto_rawDescOnce sync.Once
+	file_proto_count_proto_rawDescData []byte
+)
+
+func file_proto_count_proto_rawDescGZIP() []byte {
+	file_proto_count_proto_rawDescOnce.Do(func() {
+		file_proto_count_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_proto_count_proto_rawDesc), len(file_proto_count_proto_rawDesc)))
+	})
+	return file_proto_count_proto_rawDescData
+}
+
+var file_proto_count_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
+var file_proto_count_proto_goTypes = []any{
+	(*Count)(nil), // 0: counting.Count
+}
+var file_proto_count_proto_depIdxs = []int32{
+	0, // [0:0] is the sub-list for method output_type
+	0, // [0:0] is the sub-list for method input_type
+	0, // [0:0] is the sub-list for extension type_name
+	0, // [0:0] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_proto_count_proto_init() }
+func file_proto_count_proto_init() {
+	if File_proto_count_proto != nil {
+		return
+	}
+	file_proto_count_proto_msgTypes[0].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proto_count_proto_rawDesc), len(file_proto_count_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   1,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_proto_count_proto_goTypes,
+		DependencyIndexes: file_proto_count_proto_depIdxs,
+		MessageInfos:      file_proto_count_proto_msgTypes,
+	}.Build()
+	File_proto_count_proto = out.File
+	file_proto_count_proto_goTypes = nil
+	file_proto_count_proto_depIdxs = nil
+}
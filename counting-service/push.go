@@ -0,0 +1,72 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+const defaultPushgatewayJob = "counting-service"
+const defaultPushgatewayInterval = 15 * time.Second
+
+// MetricsPusher periodically pushes all registered metrics to a Prometheus
+// Pushgateway, so short-lived instances report even if they are never
+// scraped.
+type MetricsPusher struct {
+	pusher   *push.Pusher
+	interval time.Duration
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// StartMetricsPusher begins pushing to url every interval, grouped by job and
+// the instance name.
+func StartMetricsPusher(url, job, instance string, interval time.Duration) *MetricsPusher {
+	p := &MetricsPusher{
+		pusher:   push.New(url, job).Gatherer(prometheus.DefaultGatherer).Grouping("instance", instance),
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+func (p *MetricsPusher) run() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	p.push()
+	for {
+		select {
+		case <-ticker.C:
+			p.push()
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+func (p *MetricsPusher) push() {
+	if err := p.pusher.Push(); err != nil {
+		slog.Error("Pushgateway push failed", "error", err)
+	}
+}
+
+// Stop pushes one final time and leaves that snapshot on the Pushgateway, so
+// the last values of a short-lived instance can still be scraped after it
+// exits. The group is not deleted; it is replaced when an instance with the
+// same name pushes again.
+func (p *MetricsPusher) Stop() {
+	close(p.stop)
+	<-p.done
+
+	p.push()
+}
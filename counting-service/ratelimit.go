@@ -0,0 +1,129 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"container/list"
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxRateLimitBuckets is how many buckets a RateLimiter keeps. Past it, the
+// least recently used bucket is evicted.
+const maxRateLimitBuckets = 10000
+
+// defaultRateLimitPruneInterval is how often RunPruner drops idle buckets.
+const defaultRateLimitPruneInterval = 1 * time.Minute
+
+// RateLimiter is a set of token buckets, one per key: a counter name for
+// PER_COUNTER_MAX_RPS, a client IP for RATE_LIMIT_RPS.
+type RateLimiter struct {
+	rate  float64 // tokens per second
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*list.Element
+	lru     *list.List
+}
+
+type tokenBucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter allows rps requests per second per key, with bursts of up
+// to burst.
+func NewRateLimiter(rps float64, burst int) *RateLimiter {
+	return &RateLimiter{
+		rate:    rps,
+		burst:   float64(burst),
+		buckets: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// Allow takes a token from key's bucket. When none is left it returns false
+// and how long until one is. A nil limiter allows everything.
+func (l *RateLimiter) Allow(key string, now time.Time) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var bucket *tokenBucket
+	if elem, ok := l.buckets[key]; ok {
+		bucket = elem.Value.(*tokenBucket)
+		l.lru.MoveToFront(elem)
+	} else {
+		// The least recently used bucket has had the longest to refill, so
+		// evicting it loses the least; RunPruner drops the idle ones.
+		if l.lru.Len() >= maxRateLimitBuckets {
+			oldest := l.lru.Back()
+			l.lru.Remove(oldest)
+			delete(l.buckets, oldest.Value.(*tokenBucket).key)
+		}
+		bucket = &tokenBucket{key: key, tokens: l.burst, last: now}
+		l.buckets[key] = l.lru.PushFront(bucket)
+	}
+
+	bucket.tokens = min(l.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate)
+	bucket.last = now
+	if bucket.tokens < 1 {
+		wait := time.Duration((1 - bucket.tokens) / l.rate * float64(time.Second))
+		return false, wait
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// Prune drops buckets that have refilled completely, since a new bucket
+// starts full anyway.
+func (l *RateLimiter) Prune(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for key, elem := range l.buckets {
+		bucket := elem.Value.(*tokenBucket)
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate >= l.burst {
+			l.lru.Remove(elem)
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// RunPruner prunes idle buckets every interval until ctx is cancelled, so
+// keys seen once, like one-off client IPs, do not accumulate.
+func (l *RateLimiter) RunPruner(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			l.Prune(now)
+		}
+	}
+}
+
+// Middleware limits each client IP, answering requests over the limit with
+// 429 before they reach next.
+func (l *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ok, wait := l.Allow(clientIP(r), time.Now()); !ok {
+			clientRateLimitedTotal.Inc()
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(wait)))
+			writeJSON(w, http.StatusTooManyRequests, errorResponse{Message: "too many requests from this client"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,51 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterEvictsLeastRecentlyUsedWhenFull(t *testing.T) {
+	limiter := NewRateLimiter(1, 1)
+	now := time.Unix(0, 0)
+
+	// Every bucket is empty, so none can be pruned.
+	for i := 0; i < maxRateLimitBuckets; i++ {
+		limiter.Allow(strconv.Itoa(i), now)
+	}
+	limiter.Allow("0", now)
+	limiter.Allow("new", now)
+
+	if n := len(limiter.buckets); n != maxRateLimitBuckets {
+		t.Fatalf("buckets = %d, want %d", n, maxRateLimitBuckets)
+	}
+	if _, ok := limiter.buckets["1"]; ok {
+		t.Error("least recently used bucket was kept")
+	}
+	if ok, _ := limiter.Allow("0", now); ok {
+		t.Error("recently used bucket was evicted and refilled")
+	}
+}
+
+func TestRateLimiterPruneDropsRefilledBuckets(t *testing.T) {
+	limiter := NewRateLimiter(1, 1)
+	now := time.Unix(0, 0)
+	limiter.Allow("idle", now)
+	limiter.Allow("busy", now.Add(time.Second))
+
+	limiter.Prune(now.Add(1500 * time.Millisecond))
+
+	if _, ok := limiter.buckets["idle"]; ok {
+		t.Error("refilled bucket was not pruned")
+	}
+	if _, ok := limiter.buckets["busy"]; !ok {
+		t.Error("bucket still refilling was pruned")
+	}
+	if limiter.lru.Len() != len(limiter.buckets) {
+		t.Errorf("lru has %d entries, map %d", limiter.lru.Len(), len(limiter.buckets))
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// ReadCountHandler serves the current count in the same formats as / but
+// never increments, so dashboards can poll it without inflating it. ?since=
+// and ?format= behave as they do on /.
+func ReadCountHandler(store CounterStore, timeout time.Duration, displayFloor int64, separator string, flags *RuntimeFlags, signer *CountSigner, shedder *DBNodeShedder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			name, _, err := counterName(r)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			headCount(w, r, store, timeout, displayFloor, name)
+			return
+		}
+
+		defer shedder.Begin()()
+
+		hostname, _ := os.Hostname()
+
+		name, named, err := counterName(r)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Message: err.Error()})
+			return
+		}
+		responseName := ""
+		if named {
+			responseName = name
+		}
+
+		since, hasSince, err := parseSince(r)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Message: err.Error()})
+			return
+		}
+		formatted, err := parseFormat(r)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Message: err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		current, err := store.GetCount(ctx, name)
+		if err != nil {
+			logStoreError(r.Context(), "Store request failed", err, "counter", name)
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(timeout)))
+			writeCount(w, r, http.StatusServiceUnavailable, Count{
+				Name:     responseName,
+				Count:    -1,
+				Hostname: hostname,
+				Message:  fmt.Sprintf("DB Error: %v", err),
+			})
+			return
+		}
+
+		count := Count{
+			Name:     responseName,
+			Count:    displayCount(current, displayFloor),
+			Hostname: hostname,
+		}
+		if hasSince {
+			applySince(&count, since)
+		}
+		if formatted {
+			count.FormattedCount = formatCount(count.Count, separator)
+		}
+		if flags.DBNodeLookup.Load() && shedder.Allow() {
+			if dbNode, err := store.GetDBNode(ctx); err == nil {
+				count.DBNode = dbNode
+			}
+		}
+		signer.setHeader(w, name, count.Count)
+
+		writeCount(w, r, http.StatusOK, count)
+	}
+}
+
+// headCount answers HEAD on the count routes, which never changes the count.
+// With EXPOSE_COUNT_HEADER it reads name's count for X-Count; otherwise the
+// store is not touched at all.
+func headCount(w http.ResponseWriter, r *http.Request, store CounterStore, timeout time.Duration, displayFloor int64, name string) {
+	w.Header().Add("Vary", "Accept")
+	w.Header().Set("Content-Type", negotiateContentType(r))
+	if !exposeCountHeader {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	current, err := store.GetCount(ctx, name)
+	if err != nil {
+		logStoreError(r.Context(), "Store request failed", err, "counter", name)
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(timeout)))
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("X-Count", strconv.FormatInt(displayCount(current, displayFloor), 10))
+	w.WriteHeader(http.StatusOK)
+}
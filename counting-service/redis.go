@@ -0,0 +1,119 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const defaultRedisKey = "count"
+
+// RedisStore keeps each counter in a Redis string key and updates it with
+// INCRBY, which is atomic on the server, so replicas sharing one Redis see a
+// single counter without any locking of their own.
+type RedisStore struct {
+	client *redis.Client
+	addr   string
+	key    string
+}
+
+// NewRedisStore connects lazily to redisURL (redis:// or rediss://). The
+// default counter lives at key; named counters at key:name.
+func NewRedisStore(redisURL, key string) (*RedisStore, error) {
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, err
+	}
+	return &RedisStore{
+		client: redis.NewClient(opts),
+		addr:   opts.Addr,
+		key:    key,
+	}, nil
+}
+
+// counterKey maps a counter name to its Redis key. Counter names cannot
+// contain ':', so named keys never collide with each other or the default.
+func (s *RedisStore) counterKey(name string) string {
+	if name == defaultCounterName {
+		return s.key
+	}
+	return s.key + ":" + name
+}
+
+func (s *RedisStore) Incr(ctx context.Context, name string) (int64, error) {
+	return s.IncrBy(ctx, name, 1)
+}
+
+func (s *RedisStore) Decr(ctx context.Context, name string) (int64, error) {
+	return s.IncrBy(ctx, name, -1)
+}
+
+func (s *RedisStore) IncrBy(ctx context.Context, name string, delta int64) (int64, error) {
+	count, err := s.client.IncrBy(ctx, s.counterKey(name), delta).Result()
+	observeQueryErr(ctx, err)
+	return count, err
+}
+
+// GetCount reads a counter; a key that was never written reads as 0.
+func (s *RedisStore) GetCount(ctx context.Context, name string) (int64, error) {
+	count, err := s.client.Get(ctx, s.counterKey(name)).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	observeQueryErr(ctx, err)
+	return count, err
+}
+
+// Reset sets an existing counter back to 0. A missing key already reads as
+// 0, so it is left absent rather than created.
+func (s *RedisStore) Reset(ctx context.Context, name string) error {
+	err := s.client.SetXX(ctx, s.counterKey(name), 0, 0).Err()
+	observeQueryErr(ctx, err)
+	return err
+}
+
+// GetDBNode returns the Redis server address the store talks to.
+func (s *RedisStore) GetDBNode(ctx context.Context) (string, error) {
+	_ = ctx
+	return s.addr, nil
+}
+
+// seedScript sets the key only if it is unset or 0, in one step, so an
+// increment or another replica seeding in the meantime wins.
+var seedScript = redis.NewScript(`
+local current = tonumber(redis.call('GET', KEYS[1]) or '0')
+if current ~= 0 then
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[1])
+return 1
+`)
+
+func (s *RedisStore) SeedIfUnset(ctx context.Context, fetch func(context.Context) (int64, error)) (bool, error) {
+	if count, err := s.GetCount(ctx, defaultCounterName); err != nil || count != 0 {
+		return false, err
+	}
+
+	value, err := fetch(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	seeded, err := seedScript.Run(ctx, s.client, []string{s.key}, value).Int()
+	if err != nil {
+		return false, err
+	}
+	return seeded == 1, nil
+}
+
+func (s *RedisStore) Ping(ctx context.Context) error {
+	return s.client.Ping(ctx).Err()
+}
+
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
@@ -0,0 +1,253 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const defaultRemoteWriteInterval = 15 * time.Second
+
+// maxRemoteWriteBackoff caps how far pushes are spaced out while the
+// endpoint keeps failing.
+const maxRemoteWriteBackoff = 5 * time.Minute
+
+const remoteWriteTimeout = 10 * time.Second
+
+// RemoteWriter periodically sends all registered metrics to a Prometheus
+// remote-write endpoint, for setups that ingest through remote-write rather
+// than scraping. Each push is a full snapshot, so a failed one is not
+// retried; instead the next push is delayed, doubling up to
+// maxRemoteWriteBackoff, until the endpoint recovers.
+type RemoteWriter struct {
+	url      string
+	headers  http.Header
+	labels   []remoteWriteLabel // added to every series
+	interval time.Duration
+	client   *http.Client
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+type remoteWriteLabel struct {
+	name, value string
+}
+
+// remoteWriteError is a push the endpoint rejected. Retryable is false for
+// client errors other than 429, which resending the same data cannot fix.
+type remoteWriteError struct {
+	status    int
+	body      string
+	retryable bool
+}
+
+func (e *remoteWriteError) Error() string {
+	if e.body == "" {
+		return fmt.Sprintf("status %d", e.status)
+	}
+	return fmt.Sprintf("status %d: %s", e.status, e.body)
+}
+
+// StartRemoteWriter begins pushing to url every interval, labelling every
+// series with job and instance. headers, such as Authorization, are sent
+// with each request.
+func StartRemoteWriter(url string, headers http.Header, job, instance string, interval time.Duration) *RemoteWriter {
+	w := &RemoteWriter{
+		url:      url,
+		headers:  headers,
+		labels:   []remoteWriteLabel{{"instance", instance}, {"job", job}},
+		interval: interval,
+		client:   &http.Client{Timeout: remoteWriteTimeout},
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+func (w *RemoteWriter) run() {
+	defer close(w.done)
+
+	delay := w.interval
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			delay = w.nextDelay(delay, w.push(context.Background()))
+			timer.Reset(delay)
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// nextDelay returns the interval after a successful or unretryable push and
+// backs off after a retryable failure.
+func (w *RemoteWriter) nextDelay(current time.Duration, err error) time.Duration {
+	if err == nil {
+		return w.interval
+	}
+	if rwErr, ok := err.(*remoteWriteError); ok && !rwErr.retryable {
+		slog.Error("Remote write rejected", "url", w.url, "error", err)
+		return w.interval
+	}
+	next := min(max(current, w.interval)*2, maxRemoteWriteBackoff)
+	slog.Warn("Remote write failed; backing off", "url", w.url, "retry_in", next, "error", err)
+	return next
+}
+
+func (w *RemoteWriter) push(ctx context.Context) error {
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		return err
+	}
+	body := snappy.Encode(nil, encodeWriteRequest(families, w.labels, time.Now()))
+
+	ctx, cancel := context.WithTimeout(ctx, remoteWriteTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, values := range w.headers {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return &remoteWriteError{
+		status:    resp.StatusCode,
+		body:      strings.TrimSpace(string(msg)),
+		retryable: resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests,
+	}
+}
+
+// Stop pushes one final time so the last values recorded are not lost.
+func (w *RemoteWriter) Stop() {
+	close(w.stop)
+	<-w.done
+
+	if err := w.push(context.Background()); err != nil {
+		slog.Warn("Final remote write failed", "url", w.url, "error", err)
+	}
+}
+
+// encodeWriteRequest encodes families as a remote-write WriteRequest.
+// Histograms and summaries are flattened into their _bucket or quantile,
+// _sum and _count series, as a scrape would expose them. Field numbers
+// follow prometheus/prompb/remote.proto and types.proto.
+func encodeWriteRequest(families []*dto.MetricFamily, extra []remoteWriteLabel, now time.Time) []byte {
+	timestamp := now.UnixMilli()
+	var b []byte
+	for _, family := range families {
+		name := family.GetName()
+		for _, m := range family.GetMetric() {
+			labels := append([]remoteWriteLabel(nil), extra...)
+			for _, pair := range m.GetLabel() {
+				labels = append(labels, remoteWriteLabel{pair.GetName(), pair.GetValue()})
+			}
+			series := func(suffix string, value float64, more ...remoteWriteLabel) {
+				b = protowire.AppendTag(b, 1, protowire.BytesType)
+				b = protowire.AppendBytes(b, encodeTimeSeries(name+suffix, append(append([]remoteWriteLabel(nil), labels...), more...), value, timestamp))
+			}
+
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				series("", m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				series("", m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				series("", m.GetUntyped().GetValue())
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				for _, bucket := range h.GetBucket() {
+					series("_bucket", float64(bucket.GetCumulativeCount()), remoteWriteLabel{"le", formatFloat(bucket.GetUpperBound())})
+				}
+				series("_bucket", float64(h.GetSampleCount()), remoteWriteLabel{"le", "+Inf"})
+				series("_sum", h.GetSampleSum())
+				series("_count", float64(h.GetSampleCount()))
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.GetQuantile() {
+					series("", q.GetValue(), remoteWriteLabel{"quantile", formatFloat(q.GetQuantile())})
+				}
+				series("_sum", s.GetSampleSum())
+				series("_count", float64(s.GetSampleCount()))
+			}
+		}
+	}
+	return b
+}
+
+// encodeTimeSeries encodes one TimeSeries with a single sample. Receivers
+// reject series with duplicate label names and expect them sorted by name,
+// with __name__ first. When a name repeats, the label appended last wins, so
+// a metric's own job or instance label overrides the one added to every
+// series.
+func encodeTimeSeries(name string, labels []remoteWriteLabel, value float64, timestamp int64) []byte {
+	labels = append(labels, remoteWriteLabel{"__name__", name})
+	sort.SliceStable(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+	deduped := labels[:0]
+	for i, label := range labels {
+		if i+1 < len(labels) && labels[i+1].name == label.name {
+			continue
+		}
+		deduped = append(deduped, label)
+	}
+	labels = deduped
+
+	var b []byte
+	for _, label := range labels {
+		var l []byte
+		l = protowire.AppendTag(l, 1, protowire.BytesType)
+		l = protowire.AppendString(l, label.name)
+		l = protowire.AppendTag(l, 2, protowire.BytesType)
+		l = protowire.AppendString(l, label.value)
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, l)
+	}
+
+	var s []byte
+	s = protowire.AppendTag(s, 1, protowire.Fixed64Type)
+	s = protowire.AppendFixed64(s, math.Float64bits(value))
+	s = protowire.AppendTag(s, 2, protowire.VarintType)
+	s = protowire.AppendVarint(s, uint64(timestamp))
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	return protowire.AppendBytes(b, s)
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
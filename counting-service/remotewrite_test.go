@@ -0,0 +1,103 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// decodeSeriesLabels returns the labels of each TimeSeries in a WriteRequest
+// encoded by encodeWriteRequest, in wire order.
+func decodeSeriesLabels(t *testing.T, b []byte) [][]remoteWriteLabel {
+	t.Helper()
+	var series [][]remoteWriteLabel
+	for len(b) > 0 {
+		_, _, n := protowire.ConsumeTag(b)
+		ts, m := protowire.ConsumeBytes(b[n:])
+		if m < 0 {
+			t.Fatalf("malformed WriteRequest")
+		}
+		b = b[n+m:]
+
+		var labels []remoteWriteLabel
+		for len(ts) > 0 {
+			num, _, n := protowire.ConsumeTag(ts)
+			field, m := protowire.ConsumeBytes(ts[n:])
+			ts = ts[n+m:]
+			if num != 1 {
+				continue
+			}
+			var label remoteWriteLabel
+			for len(field) > 0 {
+				num, _, n := protowire.ConsumeTag(field)
+				value, m := protowire.ConsumeString(field[n:])
+				field = field[n+m:]
+				if num == 1 {
+					label.name = value
+				} else {
+					label.value = value
+				}
+			}
+			labels = append(labels, label)
+		}
+		series = append(series, labels)
+	}
+	return series
+}
+
+func TestEncodeWriteRequestDedupesLabels(t *testing.T) {
+	families := []*dto.MetricFamily{{
+		Name: proto.String("upstream_up"),
+		Type: dto.MetricType_GAUGE.Enum(),
+		Metric: []*dto.Metric{{
+			Label: []*dto.LabelPair{
+				{Name: proto.String("zone"), Value: proto.String("a")},
+				{Name: proto.String("job"), Value: proto.String("cockroach")},
+				{Name: proto.String("instance"), Value: proto.String("db-1")},
+			},
+			Gauge: &dto.Gauge{Value: proto.Float64(1)},
+		}},
+	}}
+	extra := []remoteWriteLabel{{"instance", "counting-1"}, {"job", "counting-service"}}
+
+	got := decodeSeriesLabels(t, encodeWriteRequest(families, extra, time.Unix(0, 0)))
+	want := [][]remoteWriteLabel{{
+		{"__name__", "upstream_up"},
+		{"instance", "db-1"},
+		{"job", "cockroach"},
+		{"zone", "a"},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("labels = %v, want %v", got, want)
+	}
+}
+
+func TestEncodeWriteRequestAddsExtraLabels(t *testing.T) {
+	families := []*dto.MetricFamily{{
+		Name: proto.String("requests_total"),
+		Type: dto.MetricType_COUNTER.Enum(),
+		Metric: []*dto.Metric{{
+			Label:   []*dto.LabelPair{{Name: proto.String("code"), Value: proto.String("200")}},
+			Counter: &dto.Counter{Value: proto.Float64(3)},
+		}},
+	}}
+	extra := []remoteWriteLabel{{"instance", "counting-1"}, {"job", "counting-service"}}
+
+	got := decodeSeriesLabels(t, encodeWriteRequest(families, extra, time.Unix(0, 0)))
+	want := [][]remoteWriteLabel{{
+		{"__name__", "requests_total"},
+		{"code", "200"},
+		{"instance", "counting-1"},
+		{"job", "counting-service"},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("labels = %v, want %v", got, want)
+	}
+}
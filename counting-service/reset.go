@@ -0,0 +1,118 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+func (m *InMemoryStore) Reset(ctx context.Context, name string) error {
+	_ = ctx
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.counts[name]; ok {
+		m.counts[name] = 0
+	}
+	return nil
+}
+
+// Reset sets counter name back to 0. A named counter that does not exist
+// already reads as 0, so there is nothing to do; the default counter follows
+// recreateMissingRow.
+func (c *CockroachStore) Reset(ctx context.Context, name string) error {
+	conn, err := c.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := c.ensureSchema(ctx, conn); err != nil {
+		observeQueryErr(ctx, err)
+		return err
+	}
+
+	if c.hmacKey != nil {
+		_, err = conn.ExecContext(ctx, `UPDATE counters SET count = 0, checksum = $2 WHERE name = $1`, name, countChecksum(c.hmacKey, name, 0))
+	} else {
+		_, err = conn.ExecContext(ctx, `UPDATE counters SET count = 0 WHERE name = $1`, name)
+	}
+	if err != nil {
+		observeQueryErr(ctx, err)
+		return err
+	}
+	if name != defaultCounterName {
+		return nil
+	}
+
+	// The default counter is seeded, so make sure it exists afterwards.
+	if !c.recreateMissingRow {
+		var exists bool
+		if err := conn.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM counters WHERE name = $1)`, name).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return errCounterRowMissing
+		}
+		return nil
+	}
+	_, err = c.insertRow(ctx, conn, name, 0)
+	return err
+}
+
+// ResetHandler zeroes the addressed counter and wakes long polls. It answers
+// 403 unless enabled, so a counter cannot be wiped by accident in production.
+func ResetHandler(store CounterStore, timeout time.Duration, displayFloor int64, enabled bool, watcher *CountWatcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !enabled {
+			writeJSON(w, http.StatusForbidden, errorResponse{Message: "reset is disabled (set RESET_ENABLED=true to allow it)"})
+			return
+		}
+
+		name, named, err := counterName(r)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Message: err.Error()})
+			return
+		}
+		responseName := ""
+		if named {
+			responseName = name
+		}
+		hostname, _ := os.Hostname()
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		// Read for the audit log only. An increment between this read and
+		// the reset is lost either way.
+		before, beforeErr := store.GetCount(ctx, name)
+		if err := store.Reset(ctx, name); err != nil {
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(timeout)))
+			writeCount(w, r, http.StatusServiceUnavailable, Count{
+				Name:     responseName,
+				Count:    -1,
+				Hostname: hostname,
+				Message:  fmt.Sprintf("DB Error: %v", err),
+			})
+			return
+		}
+		if beforeErr == nil {
+			setAuditValues(r.Context(), before, 0)
+		}
+		if name == defaultCounterName {
+			currentCount.Set(0)
+		}
+		watcher.Notify(name)
+
+		writeCount(w, r, http.StatusOK, Count{
+			Name:     responseName,
+			Count:    displayCount(0, displayFloor),
+			Hostname: hostname,
+		})
+	}
+}
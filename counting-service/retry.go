@@ -0,0 +1,74 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const (
+	defaultDBMaxRetries   = 3
+	defaultDBRetryBase    = 50 * time.Millisecond
+	sqlStateAdminShutdown = "57P01"
+	sqlStateSerialization = "40001"
+)
+
+// isRetryableWriteErr reports whether a failed write can be run again
+// without risking applying the delta twice: the statement never reached the
+// database, the connection was refused or shut down by a draining node, or
+// the transaction was aborted by a serialization failure.
+func isRetryableWriteErr(err error) bool {
+	if errors.Is(err, errPoolExhausted) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) || pgconn.SafeToRetry(err) {
+		return true
+	}
+	var connectErr *pgconn.ConnectError
+	if errors.As(err, &connectErr) {
+		return true
+	}
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && (pgErr.Code == sqlStateSerialization || pgErr.Code == sqlStateAdminShutdown)
+}
+
+// withWriteRetries runs attempt, and on a retryable error runs it again on a
+// fresh connection up to maxRetries more times, doubling the jittered wait
+// from retryBase each time. It gives up early rather than wait past ctx's
+// deadline, never retries once ctx is cancelled, and returns the last error
+// when every attempt fails.
+func (c *CockroachStore) withWriteRetries(ctx context.Context, attempt func() (int64, error)) (int64, error) {
+	backoff := c.retryBase
+	for n := 0; ; n++ {
+		count, err := attempt()
+		if err == nil || !isRetryableWriteErr(err) || n >= c.maxRetries {
+			return count, err
+		}
+		// The client may have gone away while the attempt failed for an
+		// unrelated reason; a retry would only load the database.
+		if ctx.Err() != nil {
+			return 0, err
+		}
+
+		wait := backoff/2 + rand.N(backoff)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= wait {
+			return 0, err
+		}
+		dbWriteRetriesTotal.Inc()
+		slog.WarnContext(ctx, "DB write failed; retrying", "attempt", n+1, "retry_in", wait, "error", err)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return 0, err
+		}
+		backoff *= 2
+	}
+}
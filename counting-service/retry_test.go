@@ -0,0 +1,87 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestWithWriteRetriesSkipsRetryWhenParentCancelled(t *testing.T) {
+	store := &CockroachStore{maxRetries: 3, retryBase: time.Millisecond}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	calls := 0
+	_, err := store.withWriteRetries(ctx, func() (int64, error) {
+		calls++
+		// The client goes away while the attempt fails for another reason.
+		cancel()
+		return 0, driver.ErrBadConn
+	})
+
+	if calls != 1 {
+		t.Errorf("attempt called %d times, want 1", calls)
+	}
+	if !errors.Is(err, driver.ErrBadConn) {
+		t.Errorf("error = %v, want the attempt's error", err)
+	}
+}
+
+func TestWithWriteRetries(t *testing.T) {
+	serialization := &pgconn.PgError{Code: sqlStateSerialization}
+	ambiguous := errors.New("unexpected EOF")
+
+	tests := []struct {
+		name      string
+		errs      []error // returned by successive attempts; nil succeeds
+		wantCalls int
+		wantErr   error
+	}{
+		{"success", []error{nil}, 1, nil},
+		{"retryable then success", []error{driver.ErrBadConn, serialization, nil}, 3, nil},
+		{"not retryable", []error{ambiguous}, 1, ambiguous},
+		{"gives up with last error", []error{driver.ErrBadConn, driver.ErrBadConn, driver.ErrBadConn, serialization}, 4, serialization},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &CockroachStore{maxRetries: 3, retryBase: time.Millisecond}
+			calls := 0
+			_, err := store.withWriteRetries(context.Background(), func() (int64, error) {
+				err := tt.errs[calls]
+				calls++
+				return 1, err
+			})
+			if calls != tt.wantCalls {
+				t.Errorf("attempt called %d times, want %d", calls, tt.wantCalls)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWithWriteRetriesStopsBeforeDeadline(t *testing.T) {
+	store := &CockroachStore{maxRetries: 3, retryBase: time.Second}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	calls := 0
+	start := time.Now()
+	_, _ = store.withWriteRetries(ctx, func() (int64, error) {
+		calls++
+		return 0, driver.ErrBadConn
+	})
+	if calls != 1 {
+		t.Errorf("attempt called %d times, want 1", calls)
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("waited %s for a retry that could not finish before the deadline", elapsed)
+	}
+}
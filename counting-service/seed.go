@@ -0,0 +1,154 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultSeedTimeout = 5 * time.Second
+const maxSeedResponseBytes = 4096
+
+// Seeder is implemented by stores that can set the default counter's initial
+// value once. SeedIfUnset calls fetch and stores its result only while the
+// counter is still at its initial value of 0, so a counter that has already been
+// incremented (or seeded by another replica) is never overwritten.
+type Seeder interface {
+	SeedIfUnset(ctx context.Context, fetch func(context.Context) (int64, error)) (bool, error)
+}
+
+func (m *InMemoryStore) SeedIfUnset(ctx context.Context, fetch func(context.Context) (int64, error)) (bool, error) {
+	if count, _ := m.GetCount(ctx, defaultCounterName); count != 0 {
+		return false, nil
+	}
+
+	value, err := fetch(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.counts[defaultCounterName] != 0 {
+		return false, nil
+	}
+	if m.counts == nil {
+		m.counts = make(map[string]int64)
+	}
+	m.counts[defaultCounterName] = value
+	return true, nil
+}
+
+func (c *CockroachStore) SeedIfUnset(ctx context.Context, fetch func(context.Context) (int64, error)) (bool, error) {
+	conn, err := c.conn(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	if err := c.ensureSchema(ctx, conn); err != nil {
+		return false, err
+	}
+
+	var count int64
+	if err := conn.QueryRowContext(ctx, `SELECT count FROM counters WHERE name = $1`, defaultCounterName).Scan(&count); err != nil {
+		return false, err
+	}
+	if count != 0 {
+		return false, nil
+	}
+
+	value, err := fetch(ctx)
+	if err != nil {
+		return false, err
+	}
+	// The count = 0 guard makes this a no-op if an increment or another
+	// replica got there while we were fetching.
+	var result sql.Result
+	if c.hmacKey != nil {
+		result, err = conn.ExecContext(ctx, `UPDATE counters SET count = $2, checksum = $3 WHERE name = $1 AND count = 0`, defaultCounterName, value, countChecksum(c.hmacKey, defaultCounterName, value))
+	} else {
+		result, err = conn.ExecContext(ctx, `UPDATE counters SET count = $2 WHERE name = $1 AND count = 0`, defaultCounterName, value)
+	}
+	if err != nil {
+		return false, err
+	}
+	updated, err := result.RowsAffected()
+	return updated == 1, err
+}
+
+// fetchSeed reads the initial count from url. The response body may be a
+// bare integer or a JSON object with a "count" field.
+func fetchSeed(ctx context.Context, url string) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxSeedResponseBytes))
+	if err != nil {
+		return 0, err
+	}
+
+	raw := strings.TrimSpace(string(body))
+	value, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		var payload struct {
+			Count *int64 `json:"count"`
+		}
+		if jsonErr := json.Unmarshal(body, &payload); jsonErr != nil || payload.Count == nil {
+			return 0, fmt.Errorf("response is neither an integer nor a JSON object with a count: %q", raw)
+		}
+		value = *payload.Count
+	}
+	if value < 0 {
+		return 0, fmt.Errorf("seed value %d is negative", value)
+	}
+	return value, nil
+}
+
+// seedFromURL seeds store from url if the counter is still at its initial
+// value. Failures are logged and the counter keeps its default.
+func seedFromURL(store CounterStore, url string, timeout time.Duration) {
+	seeder, ok := store.(Seeder)
+	if !ok {
+		slog.Warn("SEED_URL is set but the store does not support seeding. Ignoring it.")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var value int64
+	seeded, err := seeder.SeedIfUnset(ctx, func(ctx context.Context) (int64, error) {
+		var err error
+		value, err = fetchSeed(ctx, url)
+		return value, err
+	})
+	switch {
+	case err != nil:
+		slog.Error("Seeding the counter failed. Starting from the default.", "url", url, "error", err)
+	case seeded:
+		slog.Info("Seeded the counter", "count", value, "url", url)
+	default:
+		slog.Info("Counter already has a value. Skipping SEED_URL.")
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/url"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const sqliteInitTimeout = 10 * time.Second
+
+// SQLiteStore keeps counters in a local SQLite file, for single-node
+// deployments that need the count to survive restarts. SQLite allows one
+// writer at a time, so the pool holds a single connection: requests queue
+// for it in Go, bounded by their context, instead of failing with
+// SQLITE_BUSY.
+type SQLiteStore struct {
+	db   *sql.DB
+	path string
+}
+
+// NewSQLiteStore opens (creating if needed) the database at path and creates
+// the counters and counter_generations tables and the default counter's row.
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	query := url.Values{}
+	query.Add("_pragma", "busy_timeout(5000)")
+	query.Add("_pragma", "journal_mode(WAL)")
+	db, err := sql.Open("sqlite", "file:"+path+"?"+query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), sqliteInitTimeout)
+	defer cancel()
+
+	if _, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS counters (
+		name TEXT PRIMARY KEY,
+		count INTEGER NOT NULL
+	)`); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if _, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS counter_generations (
+		id INTEGER PRIMARY KEY,
+		count INTEGER NOT NULL,
+		rotated_at TIMESTAMP NOT NULL
+	)`); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if _, err := db.ExecContext(ctx, `INSERT OR IGNORE INTO counters (name, count) VALUES (?, 0)`, defaultCounterName); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &SQLiteStore{db: db, path: path}, nil
+}
+
+func (s *SQLiteStore) Incr(ctx context.Context, name string) (int64, error) {
+	return s.IncrBy(ctx, name, 1)
+}
+
+func (s *SQLiteStore) Decr(ctx context.Context, name string) (int64, error) {
+	return s.IncrBy(ctx, name, -1)
+}
+
+func (s *SQLiteStore) IncrBy(ctx context.Context, name string, delta int64) (int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		observeQueryErr(ctx, err)
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.ExecContext(ctx, `INSERT OR IGNORE INTO counters (name, count) VALUES (?, 0)`, name); err != nil {
+		observeQueryErr(ctx, err)
+		return 0, err
+	}
+	var count int64
+	if err := tx.QueryRowContext(ctx, `UPDATE counters SET count = count + ? WHERE name = ? RETURNING count`, delta, name).Scan(&count); err != nil {
+		observeQueryErr(ctx, err)
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		observeQueryErr(ctx, err)
+		return 0, err
+	}
+	return count, nil
+}
+
+// GetCount reads a counter; one that was never incremented reads as 0.
+func (s *SQLiteStore) GetCount(ctx context.Context, name string) (int64, error) {
+	var count int64
+	err := s.db.QueryRowContext(ctx, `SELECT count FROM counters WHERE name = ?`, name).Scan(&count)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	observeQueryErr(ctx, err)
+	return count, err
+}
+
+func (s *SQLiteStore) Reset(ctx context.Context, name string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE counters SET count = 0 WHERE name = ?`, name)
+	observeQueryErr(ctx, err)
+	return err
+}
+
+// GetDBNode returns the database file path.
+func (s *SQLiteStore) GetDBNode(ctx context.Context) (string, error) {
+	_ = ctx
+	return s.path, nil
+}
+
+func (s *SQLiteStore) SeedIfUnset(ctx context.Context, fetch func(context.Context) (int64, error)) (bool, error) {
+	if count, err := s.GetCount(ctx, defaultCounterName); err != nil || count != 0 {
+		return false, err
+	}
+
+	value, err := fetch(ctx)
+	if err != nil {
+		return false, err
+	}
+	// The count = 0 guard makes this a no-op if an increment got there while
+	// we were fetching.
+	result, err := s.db.ExecContext(ctx, `UPDATE counters SET count = ? WHERE name = ? AND count = 0`, value, defaultCounterName)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+func (s *SQLiteStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
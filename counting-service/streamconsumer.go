@@ -0,0 +1,217 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	defaultStreamGroup = "counting-service"
+
+	streamReadCount    = 100
+	streamReadBlock    = 5 * time.Second
+	streamRetryMin     = 1 * time.Second
+	streamRetryMax     = 30 * time.Second
+	streamAckTimeout   = 1 * time.Second
+	streamCounterField = "counter"
+	streamStepField    = "step"
+
+	// sqlStateNumericOutOfRange is what the database reports when an
+	// increment would overflow the count column.
+	sqlStateNumericOutOfRange = "22003"
+)
+
+// StreamConsumer increments counters from the messages of a Redis stream,
+// read through a consumer group. A message is acknowledged only after its
+// increment is stored, so delivery is at least once: a message whose write
+// succeeded but whose ack was lost is applied again when it is redelivered.
+type StreamConsumer struct {
+	client   *redis.Client
+	stream   string
+	group    string
+	consumer string
+
+	store   CounterStore
+	timeout time.Duration
+	watcher *CountWatcher
+	buckets *BucketRecorder
+}
+
+// NewStreamConsumer connects lazily to redisURL. Messages may carry a
+// "counter" field naming the counter (default "default") and a "step" field
+// with the amount to add (default 1, negative to decrement); any other
+// fields are ignored.
+func NewStreamConsumer(redisURL, stream, group, consumer string, store CounterStore, timeout time.Duration, watcher *CountWatcher, buckets *BucketRecorder) (*StreamConsumer, error) {
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, err
+	}
+	return &StreamConsumer{
+		client:   redis.NewClient(opts),
+		stream:   stream,
+		group:    group,
+		consumer: consumer,
+		store:    store,
+		timeout:  timeout,
+		watcher:  watcher,
+		buckets:  buckets,
+	}, nil
+}
+
+// Run consumes the stream until ctx is cancelled, then closes the client.
+// After an error it waits with exponential backoff, then re-reads this
+// consumer's pending messages before new ones, so nothing read but not yet
+// acknowledged is lost across Redis outages or restarts.
+func (c *StreamConsumer) Run(ctx context.Context) {
+	defer c.client.Close()
+
+	backoff := streamRetryMin
+	for ctx.Err() == nil {
+		progressed, err := c.consume(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if progressed {
+			backoff = streamRetryMin
+		}
+		slog.Warn("Stream consumer failed; retrying", "stream", c.stream, "retry_in", backoff, "error", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, streamRetryMax)
+	}
+}
+
+// consume joins the group, drains this consumer's pending messages, then
+// reads new ones until an error occurs. progressed reports whether any
+// message was handled first, so a consumer that recovered retries quickly.
+func (c *StreamConsumer) consume(ctx context.Context) (progressed bool, err error) {
+	// The group starts at the end of the stream, so creating it does not
+	// replay history that was already counted some other way.
+	err = c.client.XGroupCreateMkStream(ctx, c.stream, c.group, "$").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return false, fmt.Errorf("creating consumer group: %w", err)
+	}
+
+	// "0" reads entries delivered to this consumer but not acknowledged;
+	// ">" reads entries never delivered to the group.
+	id := "0"
+	for {
+		streams, err := c.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    c.group,
+			Consumer: c.consumer,
+			Streams:  []string{c.stream, id},
+			Count:    streamReadCount,
+			Block:    streamReadBlock,
+		}).Result()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return progressed, err
+		}
+
+		var messages []redis.XMessage
+		for _, stream := range streams {
+			messages = append(messages, stream.Messages...)
+		}
+		if id == "0" && len(messages) == 0 {
+			id = ">"
+			continue
+		}
+		for _, message := range messages {
+			if err := c.handle(ctx, message); err != nil {
+				return progressed, err
+			}
+			progressed = true
+		}
+	}
+}
+
+// handle applies one message and acknowledges it. A malformed message, or
+// one the store rejects outright, is logged and acknowledged, since
+// redelivering it would never succeed and would block every message behind
+// it; any other failed store write is returned unacknowledged so it is
+// retried.
+func (c *StreamConsumer) handle(ctx context.Context, message redis.XMessage) error {
+	name, step, err := parseStreamMessage(message.Values)
+	if err != nil {
+		slog.Warn("Dropping malformed stream message", "stream", c.stream, "id", message.ID, "error", err)
+		return c.ack(ctx, message.ID)
+	}
+
+	storeCtx, cancel := context.WithTimeout(ctx, c.timeout)
+	storeStart := time.Now()
+	newCount, err := c.store.IncrBy(storeCtx, name, step)
+	cancel()
+	if err != nil {
+		storeRequestDuration.ObserveSince(storeStart, "error")
+		incrementErrorsTotal.Inc()
+		if isPermanentStoreError(err) {
+			slog.Error("Dropping stream message the store rejected", "stream", c.stream, "id", message.ID, "counter", name, "step", step, "error", err)
+			return c.ack(ctx, message.ID)
+		}
+		return fmt.Errorf("applying message %s: %w", message.ID, err)
+	}
+	storeRequestDuration.ObserveSince(storeStart, "ok")
+
+	if name == defaultCounterName {
+		currentCount.Set(float64(newCount))
+	}
+	if step < 0 {
+		decrementsTotal.Inc()
+	} else {
+		incrementsTotal.Inc()
+		c.buckets.Record(time.Now())
+	}
+	c.watcher.Notify(name)
+	slog.Debug("Counter updated from stream", "id", message.ID, "counter", name, "step", step, "count", newCount)
+
+	return c.ack(ctx, message.ID)
+}
+
+// ack acknowledges a handled message even while shutting down, since an
+// unacknowledged one would be applied again after a restart.
+func (c *StreamConsumer) ack(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), streamAckTimeout)
+	defer cancel()
+	return c.client.XAck(ctx, c.stream, c.group, id).Err()
+}
+
+// isPermanentStoreError reports whether a store write failed because of the
+// message itself, such as a step that would overflow the count, rather than
+// because of the store.
+func isPermanentStoreError(err error) bool {
+	if errors.Is(err, errCountOverflow) {
+		return true
+	}
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == sqlStateNumericOutOfRange
+}
+
+func parseStreamMessage(values map[string]interface{}) (name string, step int64, err error) {
+	name, step = defaultCounterName, 1
+	if raw, ok := values[streamCounterField]; ok {
+		name = fmt.Sprint(raw)
+		if err := validateCounterName(name); err != nil {
+			return "", 0, err
+		}
+	}
+	if raw, ok := values[streamStepField]; ok {
+		step, err = strconv.ParseInt(strings.TrimSpace(fmt.Sprint(raw)), 10, 64)
+		if err != nil || step == 0 {
+			return "", 0, fmt.Errorf("invalid step %q", raw)
+		}
+	}
+	return name, step, nil
+}
@@ -0,0 +1,35 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestIsPermanentStoreError(t *testing.T) {
+	store := NewInMemoryStore(math.MaxInt64)
+	_, overflow := store.IncrBy(context.Background(), defaultCounterName, 1)
+
+	for _, tt := range []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"in-memory overflow", overflow, true},
+		{"wrapped overflow", fmt.Errorf("adding: %w", errCountOverflow), true},
+		{"sql overflow", &pgconn.PgError{Code: sqlStateNumericOutOfRange}, true},
+		{"serialization failure", &pgconn.PgError{Code: sqlStateSerialization}, false},
+		{"pool exhausted", errPoolExhausted, false},
+		{"timeout", context.DeadlineExceeded, false},
+	} {
+		if got := isPermanentStoreError(tt.err); got != tt.want {
+			t.Errorf("%s: isPermanentStoreError(%v) = %t, want %t", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
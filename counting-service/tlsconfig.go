@@ -0,0 +1,89 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// tlsMinVersions are the accepted TLS_MIN_VERSION values. TLS 1.0 and 1.1
+// are deprecated and fail security scans, so they are not offered.
+var tlsMinVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// defaultTLSCipherSuites are forward-secret AEAD suites only. Go's own TLS
+// 1.2 defaults also include CBC suites, which scanners flag.
+var defaultTLSCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+}
+
+// parseTLSMinVersion parses TLS_MIN_VERSION, "1.2" or "1.3". Empty means 1.2.
+func parseTLSMinVersion(raw string) (uint16, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return tls.VersionTLS12, nil
+	}
+	version, ok := tlsMinVersions[raw]
+	if !ok {
+		return 0, fmt.Errorf("unsupported TLS_MIN_VERSION %q; use 1.2 or 1.3", raw)
+	}
+	return version, nil
+}
+
+// parseTLSCipherSuites parses TLS_CIPHER_SUITES, a comma-separated list of
+// Go cipher suite names such as TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. Names
+// Go considers insecure are rejected along with unknown ones, CBC suites,
+// which Go still offers but scanners flag as weak, and TLS 1.3 suites, which
+// Go always enables and does not let the list configure. Empty means
+// defaultTLSCipherSuites.
+func parseTLSCipherSuites(raw string) ([]uint16, error) {
+	if strings.TrimSpace(raw) == "" {
+		return defaultTLSCipherSuites, nil
+	}
+
+	secure := make(map[string]*tls.CipherSuite)
+	for _, suite := range tls.CipherSuites() {
+		secure[suite.Name] = suite
+	}
+	insecure := make(map[string]bool)
+	for _, suite := range tls.InsecureCipherSuites() {
+		insecure[suite.Name] = true
+	}
+
+	var suites []uint16
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if insecure[name] {
+			return nil, fmt.Errorf("TLS_CIPHER_SUITES entry %s is insecure", name)
+		}
+		suite, ok := secure[name]
+		if !ok {
+			return nil, fmt.Errorf("TLS_CIPHER_SUITES entry %s is not a known cipher suite", name)
+		}
+		if !slices.Contains(suite.SupportedVersions, tls.VersionTLS12) {
+			return nil, fmt.Errorf("TLS_CIPHER_SUITES entry %s is a TLS 1.3 suite; TLS 1.3 suites are always enabled and cannot be configured", name)
+		}
+		if strings.Contains(name, "_CBC_") {
+			return nil, fmt.Errorf("TLS_CIPHER_SUITES entry %s is a weak CBC suite; use a GCM or ChaCha20-Poly1305 suite", name)
+		}
+		suites = append(suites, suite.ID)
+	}
+	if len(suites) == 0 {
+		return nil, fmt.Errorf("TLS_CIPHER_SUITES %q names no cipher suites", raw)
+	}
+	return suites, nil
+}
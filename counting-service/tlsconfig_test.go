@@ -0,0 +1,49 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"crypto/tls"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTLSCipherSuites(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    []uint16
+		wantErr string
+	}{
+		{"", defaultTLSCipherSuites, ""},
+		{
+			"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256",
+			[]uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256},
+			"",
+		},
+		{"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA", nil, "weak CBC suite"},
+		{"TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA", nil, "weak CBC suite"},
+		{"TLS_AES_128_GCM_SHA256", nil, "TLS 1.3 suite"},
+		{"TLS_CHACHA20_POLY1305_SHA256", nil, "TLS 1.3 suite"},
+		{"TLS_RSA_WITH_RC4_128_SHA", nil, "insecure"},
+		{"TLS_NOT_A_SUITE", nil, "not a known cipher suite"},
+		{" , ", nil, "names no cipher suites"},
+	}
+	for _, tt := range tests {
+		got, err := parseTLSCipherSuites(tt.raw)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseTLSCipherSuites(%q) error = %v, want one containing %q", tt.raw, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseTLSCipherSuites(%q) error = %v", tt.raw, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseTLSCipherSuites(%q) = %v, want %v", tt.raw, got, tt.want)
+		}
+	}
+}
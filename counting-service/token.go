@@ -0,0 +1,137 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	tokenAlgHS256 = "HS256"
+	tokenAlgRS256 = "RS256"
+)
+
+const defaultCountTokenTTL = 60 * time.Second
+
+// countTokenIssuer is the iss claim of every count token.
+const countTokenIssuer = "counting-service"
+
+// CountSigner issues short-lived JWTs vouching for a count, so a downstream
+// service can check a value it was handed without calling us again.
+type CountSigner struct {
+	alg     string
+	hmacKey []byte
+	rsaKey  *rsa.PrivateKey
+	ttl     time.Duration
+}
+
+type countClaims struct {
+	Issuer    string `json:"iss"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+	Counter   string `json:"counter"`
+	Count     int64  `json:"count"`
+}
+
+// NewCountSigner builds a signer for alg. For HS256 key is the shared secret;
+// for RS256 it is a PEM-encoded RSA private key (PKCS#1 or PKCS#8).
+func NewCountSigner(alg string, key []byte, ttl time.Duration) (*CountSigner, error) {
+	s := &CountSigner{alg: alg, ttl: ttl}
+	switch alg {
+	case tokenAlgHS256:
+		s.hmacKey = key
+	case tokenAlgRS256:
+		rsaKey, err := parseRSAPrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		s.rsaKey = rsaKey
+	default:
+		return nil, fmt.Errorf("unsupported token algorithm %q (want %s or %s)", alg, tokenAlgHS256, tokenAlgRS256)
+	}
+	return s, nil
+}
+
+func parseRSAPrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found in RSA key")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing RSA key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("PKCS#8 key is not an RSA key")
+	}
+	return key, nil
+}
+
+// Sign returns a compact JWT whose claims carry counter's count as of now.
+func (s *CountSigner) Sign(counter string, count int64, now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": s.alg, "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(countClaims{
+		Issuer:    countTokenIssuer,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(s.ttl).Unix(),
+		Counter:   counter,
+		Count:     count,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+
+	var signature []byte
+	switch s.alg {
+	case tokenAlgHS256:
+		mac := hmac.New(sha256.New, s.hmacKey)
+		mac.Write([]byte(signingInput))
+		signature = mac.Sum(nil)
+	case tokenAlgRS256:
+		digest := sha256.Sum256([]byte(signingInput))
+		signature, err = rsa.SignPKCS1v15(rand.Reader, s.rsaKey, crypto.SHA256, digest[:])
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// setHeader adds an X-Count-Token for count. A nil signer does nothing, and a
+// signing failure is logged rather than failing the request, since the token
+// is an extra on top of the count itself.
+func (s *CountSigner) setHeader(w http.ResponseWriter, counter string, count int64) {
+	if s == nil {
+		return
+	}
+	token, err := s.Sign(counter, count, time.Now())
+	if err != nil {
+		slog.Error("Failed to sign count token", "counter", counter, "error", err)
+		return
+	}
+	w.Header().Set("X-Count-Token", token)
+}
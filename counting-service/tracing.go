@@ -0,0 +1,87 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer delegates to the global tracer provider, so spans started before
+// setupTracing, or without it, are no-ops.
+var tracer = otel.Tracer("counting-service")
+
+// defaultTraceSampleRatio keeps tracing cheap at high request rates.
+const defaultTraceSampleRatio = 0.01
+
+// setupTracing installs an OTLP trace exporter when
+// OTEL_EXPORTER_OTLP_ENDPOINT is set. The exporter reads that and the other
+// standard OTEL_EXPORTER_OTLP_* variables itself. Without an endpoint,
+// tracing stays a no-op. The returned shutdown flushes pending spans.
+//
+// Root spans are sampled at sampleRatio. A span continuing a caller's trace
+// follows the caller's decision from traceparent instead, so a trace is
+// either recorded by every service or by none.
+func setupTracing(ctx context.Context, sampleRatio float64) (shutdown func(context.Context) error, enabled bool, err error) {
+	if strings.TrimSpace(os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")) == "" {
+		return func(context.Context) error { return nil }, false, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, false, fmt.Errorf("creating OTLP trace exporter: %w", err)
+	}
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", "counting-service")),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, false, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return provider.Shutdown, true, nil
+}
+
+// startServerSpan starts a request's span, continuing the caller's trace
+// when it sent a traceparent header.
+func startServerSpan(r *http.Request, name string) (*http.Request, trace.Span) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.request.method", r.Method),
+			attribute.String("url.path", r.URL.Path),
+		),
+	)
+	return r.WithContext(ctx), span
+}
+
+// endSpanWithErr records err, if any, as an event on span and marks the span
+// failed, then ends it.
+func endSpanWithErr(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
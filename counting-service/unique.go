@@ -0,0 +1,91 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"hash/maphash"
+	"math"
+	"math/bits"
+	"net/http"
+	"sync"
+)
+
+// hllPrecision gives 2^14 one-byte registers (16 KiB) and a standard error of
+// about 1.04/sqrt(2^14) ≈ 0.81%.
+const hllPrecision = 14
+
+// HyperLogLog estimates the number of distinct identifiers added to it using
+// a fixed amount of memory, regardless of how many identifiers it has seen.
+type HyperLogLog struct {
+	mu        sync.Mutex
+	seed      maphash.Seed
+	registers []uint8
+}
+
+func NewHyperLogLog() *HyperLogLog {
+	return &HyperLogLog{
+		seed:      maphash.MakeSeed(),
+		registers: make([]uint8, 1<<hllPrecision),
+	}
+}
+
+// Add records an identifier.
+func (h *HyperLogLog) Add(id string) {
+	hash := maphash.String(h.seed, id)
+	index := hash >> (64 - hllPrecision)
+	// The guard bit caps the run of leading zeros for the remaining bits.
+	rank := uint8(bits.LeadingZeros64(hash<<hllPrecision|1<<(hllPrecision-1)) + 1)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if rank > h.registers[index] {
+		h.registers[index] = rank
+	}
+}
+
+// Estimate returns the approximate number of distinct identifiers added.
+func (h *HyperLogLog) Estimate() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	m := float64(len(h.registers))
+	sum := 0.0
+	zeros := 0
+	for _, r := range h.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	alpha := 0.7213 / (1 + 1.079/m)
+	estimate := alpha * m * m / sum
+	// Linear counting is more accurate while many registers are still empty.
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(math.Round(estimate))
+}
+
+type uniqueResponse struct {
+	Unique        uint64  `json:"unique"`
+	StandardError float64 `json:"standard_error"`
+}
+
+// UniqueHandler exposes the distinct identifier estimate on GET and records
+// the identifier passed as ?id= on POST.
+func UniqueHandler(hll *HyperLogLog) http.HandlerFunc {
+	standardError := 1.04 / math.Sqrt(float64(len(hll.registers)))
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			id := r.URL.Query().Get("id")
+			if id == "" {
+				writeJSON(w, http.StatusBadRequest, errorResponse{Message: "id is required"})
+				return
+			}
+			hll.Add(id)
+		}
+		writeJSON(w, http.StatusOK, uniqueResponse{Unique: hll.Estimate(), StandardError: standardError})
+	}
+}
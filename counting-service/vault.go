@@ -0,0 +1,147 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const vaultRequestTimeout = 10 * time.Second
+
+// maxVaultResponseBytes bounds how much of a Vault response is read.
+const maxVaultResponseBytes = 1 << 20
+
+// vaultPlaceholder matches ${VAULT:<path>#<key>}, e.g.
+// ${VAULT:secret/db#password}.
+var vaultPlaceholder = regexp.MustCompile(`\$\{VAULT:([^#}]+)#([^}]+)\}`)
+
+// resolveVaultSecrets replaces each ${VAULT:path#key} in raw with that key of
+// the Vault secret at path, read with VAULT_ADDR and VAULT_TOKEN. Values are
+// escaped for where they appear: percent-encoded in a URL, and quoted in a
+// keyword/value connection string such as "host=db password=${VAULT:...}".
+// A string without placeholders is returned unchanged without contacting
+// Vault.
+func resolveVaultSecrets(ctx context.Context, raw string) (string, error) {
+	matches := vaultPlaceholder.FindAllStringSubmatch(raw, -1)
+	if len(matches) == 0 {
+		return raw, nil
+	}
+
+	addr := strings.TrimRight(strings.TrimSpace(os.Getenv("VAULT_ADDR")), "/")
+	token := strings.TrimSpace(os.Getenv("VAULT_TOKEN"))
+	if addr == "" || token == "" {
+		return "", errors.New("VAULT_ADDR and VAULT_TOKEN must be set to resolve ${VAULT:...} placeholders")
+	}
+
+	client := &http.Client{Timeout: vaultRequestTimeout}
+	secrets := make(map[string]map[string]any)
+	values := make(map[string]string)
+	for _, match := range matches {
+		placeholder, path, key := match[0], strings.Trim(match[1], "/"), match[2]
+		secret, ok := secrets[path]
+		if !ok {
+			var err error
+			secret, err = readVaultSecret(ctx, client, addr, token, path)
+			if err != nil {
+				return "", fmt.Errorf("reading Vault secret %s: %w", path, err)
+			}
+			secrets[path] = secret
+		}
+		value, ok := secret[key].(string)
+		if !ok {
+			return "", fmt.Errorf("Vault secret %s has no string key %q", path, key)
+		}
+		values[placeholder] = value
+	}
+
+	isURL := strings.Contains(raw, "://")
+	var resolved strings.Builder
+	last := 0
+	for _, loc := range vaultPlaceholder.FindAllStringIndex(raw, -1) {
+		resolved.WriteString(raw[last:loc[0]])
+		value := values[raw[loc[0]:loc[1]]]
+		switch {
+		case isURL:
+			resolved.WriteString(strings.ReplaceAll(url.QueryEscape(value), "+", "%20"))
+		case loc[0] > 0 && raw[loc[0]-1] == '\'':
+			// Already inside a quoted value, e.g. password='${VAULT:...}'.
+			resolved.WriteString(dsnValueEscaper.Replace(value))
+		default:
+			resolved.WriteString("'" + dsnValueEscaper.Replace(value) + "'")
+		}
+		last = loc[1]
+	}
+	resolved.WriteString(raw[last:])
+	return resolved.String(), nil
+}
+
+// dsnValueEscaper escapes a value for single quotes in a keyword/value
+// connection string.
+var dsnValueEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+
+// readVaultSecret reads a KV secret. A KV version 2 path may be given with
+// or without its data/ segment: when the plain path is not found, it is
+// retried as <mount>/data/<rest>.
+func readVaultSecret(ctx context.Context, client *http.Client, addr, token, path string) (map[string]any, error) {
+	secret, status, err := getVaultSecret(ctx, client, addr, token, path)
+	if status == http.StatusNotFound {
+		if mount, rest, ok := strings.Cut(path, "/"); ok && !strings.HasPrefix(rest, "data/") {
+			secret, _, err = getVaultSecret(ctx, client, addr, token, mount+"/data/"+rest)
+		}
+	}
+	return secret, err
+}
+
+func getVaultSecret(ctx context.Context, client *http.Client, addr, token, path string) (map[string]any, int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, addr+"/v1/"+path, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("X-Vault-Token", token)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxVaultResponseBytes))
+	if err != nil {
+		return nil, resp.StatusCode, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var failure struct {
+			Errors []string `json:"errors"`
+		}
+		_ = json.Unmarshal(body, &failure)
+		if len(failure.Errors) == 0 {
+			return nil, resp.StatusCode, fmt.Errorf("status %d", resp.StatusCode)
+		}
+		return nil, resp.StatusCode, fmt.Errorf("status %d: %s", resp.StatusCode, strings.Join(failure.Errors, "; "))
+	}
+
+	var secret struct {
+		Data map[string]any `json:"data"`
+	}
+	if err := json.Unmarshal(body, &secret); err != nil {
+		return nil, resp.StatusCode, fmt.Errorf("unexpected response: %w", err)
+	}
+	// KV version 2 nests the values under data.data, next to metadata.
+	if nested, ok := secret.Data["data"].(map[string]any); ok {
+		if _, hasMetadata := secret.Data["metadata"]; hasMetadata {
+			return nested, resp.StatusCode, nil
+		}
+	}
+	return secret.Data, resp.StatusCode, nil
+}
@@ -0,0 +1,64 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const vaultTestPassword = `p@ss word/'q\`
+
+func newTestVault(t *testing.T) {
+	t.Helper()
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/secret/data/db" || r.Header.Get("X-Vault-Token") != "test-token" {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"data": map[string]any{
+				"data":     map[string]any{"password": vaultTestPassword},
+				"metadata": map[string]any{"version": 1},
+			},
+		})
+	}))
+	t.Cleanup(vault.Close)
+	t.Setenv("VAULT_ADDR", vault.URL)
+	t.Setenv("VAULT_TOKEN", "test-token")
+}
+
+func TestResolveVaultSecretsEscapesForConnectionStringForm(t *testing.T) {
+	newTestVault(t)
+	for _, raw := range []string{
+		"postgresql://counting:${VAULT:secret/db#password}@cockroach:26257/counting",
+		"host=cockroach port=26257 user=counting password=${VAULT:secret/db#password} dbname=counting",
+		"host=cockroach port=26257 user=counting password='${VAULT:secret/db#password}' dbname=counting",
+	} {
+		resolved, err := resolveVaultSecrets(context.Background(), raw)
+		if err != nil {
+			t.Fatalf("resolveVaultSecrets(%q): %v", raw, err)
+		}
+		config, err := pgconn.ParseConfig(resolved)
+		if err != nil {
+			t.Fatalf("parsing %q resolved from %q: %v", resolved, raw, err)
+		}
+		if config.Password != vaultTestPassword || config.User != "counting" || config.Database != "counting" {
+			t.Errorf("%q resolved to user %q, password %q, database %q", raw, config.User, config.Password, config.Database)
+		}
+	}
+}
+
+func TestResolveVaultSecretsWithoutPlaceholders(t *testing.T) {
+	t.Setenv("VAULT_ADDR", "")
+	raw := "host=cockroach password=plain"
+	resolved, err := resolveVaultSecrets(context.Background(), raw)
+	if err != nil || resolved != raw {
+		t.Errorf("resolveVaultSecrets(%q) = %q, %v; want it unchanged", raw, resolved, err)
+	}
+}
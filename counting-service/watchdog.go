@@ -0,0 +1,173 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const defaultWatchdogTimeout = 2 * time.Second
+const defaultWatchdogFailureThreshold = 3
+
+// Watchdog periodically checks that the store still answers a trivial read.
+// It detects hangs such as a deadlocked mutex, not DB outages: a probe that
+// returns an error quickly is a healthy process talking to a broken DB. Only
+// probes that fail to return at all count as failures.
+type Watchdog struct {
+	store     CounterStore
+	interval  time.Duration
+	timeout   time.Duration
+	threshold int
+	exit      bool
+
+	mu       sync.Mutex
+	failures int
+	probing  bool
+}
+
+func NewWatchdog(store CounterStore, interval, timeout time.Duration, threshold int, exit bool) *Watchdog {
+	return &Watchdog{store: store, interval: interval, timeout: timeout, threshold: threshold, exit: exit}
+}
+
+// Run probes the store every interval until ctx is cancelled.
+func (wd *Watchdog) Run(ctx context.Context) {
+	ticker := time.NewTicker(wd.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			wd.probe()
+		}
+	}
+}
+
+func (wd *Watchdog) probe() {
+	wd.mu.Lock()
+	if wd.probing {
+		// The previous probe is still stuck, which is itself a failure.
+		wd.mu.Unlock()
+		wd.recordFailure()
+		return
+	}
+	wd.probing = true
+	wd.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), wd.timeout)
+		defer cancel()
+		_, _ = wd.store.GetCount(ctx, defaultCounterName)
+
+		wd.mu.Lock()
+		wd.probing = false
+		wd.mu.Unlock()
+		close(done)
+	}()
+
+	// The probe's context expires after timeout; anything that honors its
+	// context returns shortly after, so waiting twice as long only trips on
+	// operations that ignore cancellation.
+	select {
+	case <-done:
+		wd.mu.Lock()
+		if wd.failures >= wd.threshold {
+			slog.Info("Watchdog: store responsive again", "failed_probes", wd.failures)
+		}
+		wd.failures = 0
+		wd.mu.Unlock()
+	case <-time.After(2 * wd.timeout):
+		wd.recordFailure()
+	}
+}
+
+func (wd *Watchdog) recordFailure() {
+	wd.mu.Lock()
+	wd.failures++
+	failures := wd.failures
+	wd.mu.Unlock()
+
+	slog.Warn("Watchdog: store probe did not return in time", "timeout", 2*wd.timeout, "failures", failures, "threshold", wd.threshold)
+	if failures >= wd.threshold && wd.exit {
+		slog.Error("Watchdog: store appears hung. Exiting so the orchestrator restarts the process.")
+		os.Exit(1)
+	}
+}
+
+// Healthy reports false once the failure threshold has been reached.
+func (wd *Watchdog) Healthy() (bool, int) {
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+	return wd.failures < wd.threshold, wd.failures
+}
+
+// healthHandler reports liveness, failing with 503 while the watchdog
+// considers the store hung. When ready is non-nil (COMBINED_HEALTH), it also
+// runs the readiness check, for orchestrators that can only probe one path.
+func healthHandler(wd *Watchdog, ready func(context.Context) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if wd != nil {
+			if healthy, failures := wd.Healthy(); !healthy {
+				http.Error(w, fmt.Sprintf("store unresponsive: %d consecutive watchdog probes hung", failures), http.StatusServiceUnavailable)
+				return
+			}
+		}
+		if ready != nil {
+			if err := ready(r.Context()); err != nil {
+				http.Error(w, fmt.Sprintf("not ready: %v", err), http.StatusServiceUnavailable)
+				return
+			}
+		}
+		HealthHandler(w, r)
+	}
+}
+
+// Pinger is implemented by stores backed by a server that can be
+// unreachable. Stores without it, such as memory, are always ready.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// storeReadiness returns a check that the store's backend answers a ping
+// within timeout. Unlike the watchdog, a quick DB error counts as not ready.
+func storeReadiness(store CounterStore, timeout time.Duration) func(context.Context) error {
+	return func(ctx context.Context) error {
+		pinger, ok := store.(Pinger)
+		if !ok {
+			return nil
+		}
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		if err := pinger.Ping(ctx); err != nil {
+			return fmt.Errorf("store unreachable: %w", err)
+		}
+		return nil
+	}
+}
+
+type readyResponse struct {
+	Status string `json:"status"`
+}
+
+// readyzHandler reports readiness: 200 when the store is reachable, 503
+// otherwise, so load balancers stop routing to an instance whose DB is down.
+func readyzHandler(ready func(context.Context) error, timeout time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := ready(r.Context()); err != nil {
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(timeout)))
+			writeJSON(w, http.StatusServiceUnavailable, errorResponse{Message: "not ready: " + err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, readyResponse{Status: "ready"})
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// WeightedRoute maps a path to a fixed delta on a counter, so for example
+// /vote/up and /vote/down can add 1 and -1 to the same score.
+type WeightedRoute struct {
+	Path    string `json:"path"`
+	Counter string `json:"counter"`
+	Weight  int64  `json:"weight"`
+}
+
+// builtinPaths are the service's own routes. A weighted route on one of them,
+// or under /admin/ or /counter/, would hide it or be hidden by it.
+var builtinPaths = []string{
+	"/badge.svg", "/buckets", "/cluster", "/count", "/decr", "/echo", "/generations",
+	"/health", "/metrics", "/poll", "/readyz", "/reset", "/unique", "/version",
+}
+
+var builtinPathPrefixes = []string{"/admin", "/counter"}
+
+// shadowsBuiltinPath reports whether path is, or falls under, a built-in
+// route.
+func shadowsBuiltinPath(path string) bool {
+	path = strings.TrimSuffix(path, "/")
+	if slices.Contains(builtinPaths, path) {
+		return true
+	}
+	for _, prefix := range builtinPathPrefixes {
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// loadWeightedRoutes reads a JSON array of WeightedRoute from path. Counter
+// defaults to defaultCounterName.
+func loadWeightedRoutes(path string) ([]WeightedRoute, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var routes []WeightedRoute
+	if err := json.Unmarshal(data, &routes); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	seen := make(map[string]bool, len(routes))
+	for i := range routes {
+		route := &routes[i]
+		if !strings.HasPrefix(route.Path, "/") || route.Path == "/" {
+			return nil, fmt.Errorf("route %d: path %q must start with / and not be / itself", i, route.Path)
+		}
+		if shadowsBuiltinPath(route.Path) {
+			return nil, fmt.Errorf("route %d: path %q is used by a built-in route", i, route.Path)
+		}
+		if seen[route.Path] {
+			return nil, fmt.Errorf("route %d: duplicate path %q", i, route.Path)
+		}
+		seen[route.Path] = true
+		if route.Weight == 0 {
+			return nil, fmt.Errorf("route %d (%s): weight must be non-zero", i, route.Path)
+		}
+		if route.Counter == "" {
+			route.Counter = defaultCounterName
+		}
+		if err := validateCounterName(route.Counter); err != nil {
+			return nil, fmt.Errorf("route %d (%s): %w", i, route.Path, err)
+		}
+	}
+	return routes, nil
+}
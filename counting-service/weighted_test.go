@@ -0,0 +1,41 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadWeightedRoutesRejectsBuiltinPaths(t *testing.T) {
+	tests := []struct {
+		path    string
+		wantErr bool
+	}{
+		{"/vote/up", false},
+		{"/healthy", false},
+		{"/counters", false},
+		{"/health", true},
+		{"/reset/", true},
+		{"/decr", true},
+		{"/admin", true},
+		{"/admin/rotate", true},
+		{"/counter/votes", true},
+	}
+	for _, tt := range tests {
+		file := filepath.Join(t.TempDir(), "routes.json")
+		if err := os.WriteFile(file, []byte(`[{"path": "`+tt.path+`", "weight": 1}]`), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		_, err := loadWeightedRoutes(file)
+		if tt.wantErr && (err == nil || !strings.Contains(err.Error(), "built-in route")) {
+			t.Errorf("path %s: error = %v, want a built-in route error", tt.path, err)
+		}
+		if !tt.wantErr && err != nil {
+			t.Errorf("path %s: unexpected error %v", tt.path, err)
+		}
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -26,6 +27,7 @@ import (
 const defaultDNSNetwork = "udp"
 const defaultDNSPort = "53"
 const defaultDNSTimeout = 1500 * time.Millisecond
+const defaultDNSMaxTimeout = 5 * time.Second
 
 //go:embed assets
 var staticFiles embed.FS
@@ -33,6 +35,16 @@ var staticFiles embed.FS
 var countingServiceURL string
 var port string
 
+// streamSubscribers is the number of open /ws connections, published on
+// /metrics as stream_subscribers.
+var streamSubscribers atomic.Int64
+
+func init() {
+	expvar.Publish("stream_subscribers", expvar.Func(func() any {
+		return streamSubscribers.Load()
+	}))
+}
+
 var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool {
 		return true // Allow all origins for this demo
@@ -52,10 +64,16 @@ func main() {
 	fmt.Printf("Using counting service at %s\n", countingServiceURL)
 	fmt.Println("(Pass as COUNTING_SERVICE_URL environment variable)")
 
+	maxSubscribers := getMaxStreamSubscribers()
+	if maxSubscribers > 0 {
+		fmt.Printf("Allowing at most %d WebSocket subscribers\n", maxSubscribers)
+		fmt.Println("(Pass as MAX_STREAM_SUBSCRIBERS environment variable)")
+	}
+
 	failTrack := new(failureTracker)
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("/ws", wsHandler(failTrack))
+	mux.HandleFunc("/ws", wsHandler(failTrack, maxSubscribers))
 	mux.HandleFunc("/health", HealthHandler)
 	mux.HandleFunc("/health/api", HealthAPIHandler(failTrack))
 	mux.Handle("/metrics", expvar.Handler())
@@ -75,6 +93,38 @@ func getEnvOrDefault(key, fallback string) string {
 	return fallback
 }
 
+// getMaxStreamSubscribers returns the MAX_STREAM_SUBSCRIBERS limit on
+// concurrent /ws connections. Zero means unlimited.
+func getMaxStreamSubscribers() int64 {
+	raw := strings.TrimSpace(os.Getenv("MAX_STREAM_SUBSCRIBERS"))
+	if raw == "" {
+		return 0
+	}
+
+	limit, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || limit < 0 {
+		log.Printf("Invalid MAX_STREAM_SUBSCRIBERS=%q. Using default of no limit.", raw)
+		return 0
+	}
+
+	return limit
+}
+
+// acquireSubscriber claims a subscriber slot, failing once limit slots are
+// taken. A limit of zero never fails. Each successful acquire must be paired
+// with a decrement of streamSubscribers.
+func acquireSubscriber(limit int64) bool {
+	for {
+		current := streamSubscribers.Load()
+		if limit > 0 && current >= limit {
+			return false
+		}
+		if streamSubscribers.CompareAndSwap(current, current+1) {
+			return true
+		}
+	}
+}
+
 func getCustomDNSServer() string {
 	dnsServer := strings.TrimSpace(os.Getenv("DNS_SERVER"))
 	if dnsServer != "" {
@@ -92,15 +142,37 @@ func getCustomDNSNetwork() string {
 }
 
 func getCustomDNSTimeout() time.Duration {
+	timeout := defaultDNSTimeout
+
 	raw := strings.TrimSpace(os.Getenv("DNS_TIMEOUT_MS"))
+	if raw != "" {
+		ms, err := strconv.Atoi(raw)
+		if err != nil || ms <= 0 {
+			log.Printf("Invalid DNS_TIMEOUT_MS=%q. Using default %s.", raw, defaultDNSTimeout)
+		} else {
+			timeout = time.Duration(ms) * time.Millisecond
+		}
+	}
+
+	maxTimeout := getCustomDNSMaxTimeout()
+	if timeout > maxTimeout {
+		log.Printf("DNS timeout %s exceeds DNS_MAX_TIMEOUT_MS. Clamping to %s.", timeout, maxTimeout)
+		return maxTimeout
+	}
+
+	return timeout
+}
+
+func getCustomDNSMaxTimeout() time.Duration {
+	raw := strings.TrimSpace(os.Getenv("DNS_MAX_TIMEOUT_MS"))
 	if raw == "" {
-		return defaultDNSTimeout
+		return defaultDNSMaxTimeout
 	}
 
 	ms, err := strconv.Atoi(raw)
 	if err != nil || ms <= 0 {
-		log.Printf("Invalid DNS_TIMEOUT_MS=%q. Using default %s.", raw, defaultDNSTimeout)
-		return defaultDNSTimeout
+		log.Printf("Invalid DNS_MAX_TIMEOUT_MS=%q. Using default %s.", raw, defaultDNSMaxTimeout)
+		return defaultDNSMaxTimeout
 	}
 
 	return time.Duration(ms) * time.Millisecond
@@ -209,9 +281,19 @@ func HealthAPIHandler(ft *failureTracker) http.HandlerFunc {
 	}
 }
 
-// wsHandler handles WebSocket connections from the dashboard frontend.
-func wsHandler(ft *failureTracker) http.HandlerFunc {
+// wsHandler handles WebSocket connections from the dashboard frontend. Once
+// maxSubscribers connections are open, new ones are refused with a 503 before
+// the upgrade.
+func wsHandler(ft *failureTracker, maxSubscribers int64) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if !acquireSubscriber(maxSubscribers) {
+			log.Printf("Refusing WebSocket client: %d subscribers already connected", maxSubscribers)
+			w.Header().Set("Retry-After", "5")
+			http.Error(w, fmt.Sprintf("Too many dashboard subscribers (limit %d). Try again later.", maxSubscribers), http.StatusServiceUnavailable)
+			return
+		}
+		defer streamSubscribers.Add(-1)
+
 		conn, err := upgrader.Upgrade(w, r, nil)
 		if err != nil {
 			log.Println("WebSocket upgrade error:", err)